@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// Config holds grantd's application settings.
+type Config struct {
+	DBHost     string
+	DBPort     int
+	DBName     string
+	DBUser     string
+	DBPassword string
+
+	ListenAddr string // HTTP listen address, e.g. ":8097"
+
+	// ArchiveRoot is where the ipgYYMMDD.zip archives named by
+	// patent_grant_segments.raw_xml_source actually live on disk.
+	ArchiveRoot string
+
+	// CacheSize bounds how many *zip.ReadCloser handles zipCache keeps
+	// open at once - each one holds a parsed ZIP central directory in
+	// memory, so this trades memory for skipping repeat zip.OpenReader
+	// central-directory parses on archives requests keep coming back to.
+	CacheSize int
+
+	// WarmCount is how many of the most recently updated archives -warm
+	// pre-opens at startup, so the first request against today's archive
+	// doesn't pay the OpenReader cost on the request path.
+	WarmCount int
+}
+
+// Default configuration - can be overridden via environment variables
+var cfg = Config{
+	DBHost:      getEnv("DB_HOST", "localhost"),
+	DBPort:      getEnvInt("DB_PORT", 5432),
+	DBName:      getEnv("DB_NAME", "companies_db"),
+	DBUser:      getEnv("DB_USER", "postgres"),
+	DBPassword:  getEnv("DB_PASSWORD", "qwklmn711"),
+	ListenAddr:  getEnv("GRANTD_LISTEN_ADDR", ":8097"),
+	ArchiveRoot: getEnv("GRANTD_ARCHIVE_ROOT", "/mnt/patents/data/grants/xml"),
+	CacheSize:   getEnvInt("GRANTD_ZIP_CACHE_SIZE", 16),
+	WarmCount:   getEnvInt("GRANTD_WARM_COUNT", 0),
+}
+
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// readGrantRange reads exactly length bytes starting at offset from zf's
+// decompressed stream. DEFLATE isn't randomly seekable, so this discards up
+// to offset rather than buffering the whole entry the way
+// readGrantArchiveEntry in grant_extractor.go does - the entries grantd
+// reads from tend to be requested one grant at a time against multi-GB
+// archives, so paying for the whole file every lookup isn't worth it here.
+func readGrantRange(zf *zip.File, offset, length int64) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", zf.Name, err)
+	}
+	defer rc.Close()
+
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+		return nil, fmt.Errorf("seeking to offset %d in %s: %w", offset, zf.Name, err)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(rc, buf); err != nil {
+		return nil, fmt.Errorf("reading %d bytes at offset %d in %s: %w", length, offset, zf.Name, err)
+	}
+	return buf, nil
+}
+
+// xmlClaims/xmlClaim, xmlReferences/xmlCitation, and xmlParagraphs mirror
+// the matching structs in grant_extractor.go's USPatentGrant - duplicated
+// rather than imported since this repo has no go.mod to share a package
+// across binaries, same as prior_art_api's own independent models.
+type xmlClaims struct {
+	Claims []xmlClaim `xml:"claim"`
+}
+
+type xmlClaim struct {
+	Num  string `xml:"num,attr"`
+	Text string `xml:",innerxml"`
+}
+
+type xmlReferences struct {
+	Citations []xmlCitation `xml:"us-citation"`
+}
+
+type xmlCitation struct {
+	Category string     `xml:"category"`
+	PatCit   *xmlPatCit `xml:"patcit"`
+	NplCit   *xmlNplCit `xml:"nplcit"`
+}
+
+type xmlPatCit struct {
+	Num   string   `xml:"num,attr"`
+	DocID xmlDocID `xml:"document-id"`
+}
+
+type xmlDocID struct {
+	Country   string `xml:"country"`
+	DocNumber string `xml:"doc-number"`
+	Kind      string `xml:"kind"`
+	Date      string `xml:"date"`
+}
+
+type xmlNplCit struct {
+	Othercit string `xml:"othercit"`
+}
+
+type xmlParagraphs struct {
+	Paragraphs []string `xml:"p"`
+}
+
+// extractSubtree scans a <us-patent-grant> element's raw bytes for the
+// first child matching elementName (one of "claims", "us-references-cited",
+// "description", "abstract") and returns its exact XML fragment.
+func extractSubtree(raw []byte, elementName string) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(raw))
+
+	for {
+		startOffset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("XML decode error: %v", err)
+		}
+
+		se, ok := token.(xml.StartElement)
+		if !ok || se.Name.Local != elementName {
+			continue
+		}
+
+		if err := decoder.Skip(); err != nil {
+			return nil, fmt.Errorf("skipping <%s>: %v", elementName, err)
+		}
+		endOffset := decoder.InputOffset()
+
+		if startOffset < 0 || endOffset > int64(len(raw)) || startOffset > endOffset {
+			return nil, fmt.Errorf("subtree <%s> range [%d:%d] out of bounds (len %d)", elementName, startOffset, endOffset, len(raw))
+		}
+		return raw[startOffset:endOffset], nil
+	}
+
+	return nil, fmt.Errorf("no <%s> element found", elementName)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+)
+
+// GrantService is written the shape a generated gRPC service implementation
+// would be - one method per RPC, StreamDescription taking a send callback
+// in place of a grpc.ServerStream - so that wiring an actual GrantService
+// gRPC server around it later is a matter of adding the
+// google.golang.org/grpc dependency and a .proto file, not restructuring
+// this logic. This repo has no go.mod to pull in grpc and run protoc
+// against, so for now the HTTP handlers in handlers.go are the only
+// transport calling into it.
+type GrantService struct {
+	cache  *zipCache
+	lookup *grantSegmentLookup
+	root   string
+}
+
+func NewGrantService(db *sql.DB, cache *zipCache, archiveRoot string) *GrantService {
+	return &GrantService{
+		cache:  cache,
+		lookup: &grantSegmentLookup{db: db},
+		root:   archiveRoot,
+	}
+}
+
+// subtree resolves grantNumber's location, opens (or reuses) the archive
+// through the cache, and returns the raw XML fragment for elementName.
+func (s *GrantService) subtree(grantNumber, elementName string) ([]byte, error) {
+	loc, err := s.lookup.find(grantNumber)
+	if err != nil {
+		return nil, fmt.Errorf("grant %s: %w", grantNumber, err)
+	}
+
+	r, err := s.cache.get(filepath.Join(s.root, loc.ArchiveName))
+	if err != nil {
+		return nil, fmt.Errorf("opening archive %s: %w", loc.ArchiveName, err)
+	}
+
+	var zf *zip.File
+	for _, f := range r.File {
+		if f.Name == loc.EntryName {
+			zf = f
+			break
+		}
+	}
+	if zf == nil {
+		return nil, fmt.Errorf("entry %s not found in %s", loc.EntryName, loc.ArchiveName)
+	}
+
+	raw, err := readGrantRange(zf, loc.ByteOffset, loc.ByteLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return extractSubtree(raw, elementName)
+}
+
+// GetClaims returns grantNumber's claims both parsed (for JSON responses)
+// and as the original XML fragment.
+func (s *GrantService) GetClaims(grantNumber string) (*xmlClaims, []byte, error) {
+	fragment, err := s.subtree(grantNumber, "claims")
+	if err != nil {
+		return nil, nil, err
+	}
+	var claims xmlClaims
+	if err := xml.Unmarshal(fragment, &claims); err != nil {
+		return nil, nil, fmt.Errorf("parsing claims for %s: %w", grantNumber, err)
+	}
+	return &claims, fragment, nil
+}
+
+// GetCitations returns grantNumber's cited references, optionally filtered
+// to one category ("applicant", "examiner", ...). An empty category
+// returns everything.
+func (s *GrantService) GetCitations(grantNumber, category string) (*xmlReferences, []byte, error) {
+	fragment, err := s.subtree(grantNumber, "us-references-cited")
+	if err != nil {
+		return nil, nil, err
+	}
+	var refs xmlReferences
+	if err := xml.Unmarshal(fragment, &refs); err != nil {
+		return nil, nil, fmt.Errorf("parsing citations for %s: %w", grantNumber, err)
+	}
+
+	if category == "" {
+		return &refs, fragment, nil
+	}
+	filtered := refs
+	filtered.Citations = nil
+	for _, c := range refs.Citations {
+		if c.Category == category {
+			filtered.Citations = append(filtered.Citations, c)
+		}
+	}
+	return &filtered, fragment, nil
+}
+
+// GetAbstract returns grantNumber's abstract.
+func (s *GrantService) GetAbstract(grantNumber string) (*xmlParagraphs, []byte, error) {
+	fragment, err := s.subtree(grantNumber, "abstract")
+	if err != nil {
+		return nil, nil, err
+	}
+	var paras xmlParagraphs
+	if err := xml.Unmarshal(fragment, &paras); err != nil {
+		return nil, nil, fmt.Errorf("parsing abstract for %s: %w", grantNumber, err)
+	}
+	return &paras, fragment, nil
+}
+
+// StreamDescription parses grantNumber's description and calls send once
+// per paragraph, stopping at the first error send returns - the
+// server-streaming RPC shape for what can be the largest sub-document on a
+// grant.
+func (s *GrantService) StreamDescription(grantNumber string, send func(paragraph string) error) error {
+	fragment, err := s.subtree(grantNumber, "description")
+	if err != nil {
+		return err
+	}
+	var paras xmlParagraphs
+	if err := xml.Unmarshal(fragment, &paras); err != nil {
+		return fmt.Errorf("parsing description for %s: %w", grantNumber, err)
+	}
+	for _, p := range paras.Paragraphs {
+		if err := send(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// apiResponse mirrors prior_art_api's APIResponse wrapper shape, with a
+// generic Data field since grantd's endpoints return several different
+// payload types (claims, citations, paragraphs).
+type apiResponse struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiResponse{Success: true, Data: data})
+}
+
+func sendXMLFragment(w http.ResponseWriter, fragment []byte) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(fragment)
+}
+
+func sendError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiResponse{Success: false, Error: message})
+}
+
+// handleHealth serves the health check endpoint
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleClaims serves GET /grants/{number}/claims (?format=xml for the raw
+// fragment instead of parsed JSON).
+func handleClaims(w http.ResponseWriter, r *http.Request) {
+	grantNumber := r.PathValue("number")
+	claims, fragment, err := service.GetClaims(grantNumber)
+	if err != nil {
+		log.Printf("claims %s: %v", grantNumber, err)
+		sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if r.URL.Query().Get("format") == "xml" {
+		sendXMLFragment(w, fragment)
+		return
+	}
+	sendJSON(w, claims)
+}
+
+// handleCitations serves GET /grants/{number}/citations?category=applicant
+func handleCitations(w http.ResponseWriter, r *http.Request) {
+	grantNumber := r.PathValue("number")
+	category := r.URL.Query().Get("category")
+
+	refs, fragment, err := service.GetCitations(grantNumber, category)
+	if err != nil {
+		log.Printf("citations %s: %v", grantNumber, err)
+		sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if r.URL.Query().Get("format") == "xml" {
+		sendXMLFragment(w, fragment)
+		return
+	}
+	sendJSON(w, refs)
+}
+
+// handleAbstract serves GET /grants/{number}/abstract
+func handleAbstract(w http.ResponseWriter, r *http.Request) {
+	grantNumber := r.PathValue("number")
+	abstract, fragment, err := service.GetAbstract(grantNumber)
+	if err != nil {
+		log.Printf("abstract %s: %v", grantNumber, err)
+		sendError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if r.URL.Query().Get("format") == "xml" {
+		sendXMLFragment(w, fragment)
+		return
+	}
+	sendJSON(w, abstract)
+}
+
+// handleDescription serves GET /grants/{number}/description, streaming one
+// JSON-encoded paragraph at a time and flushing after each - descriptions
+// are routinely the largest sub-document on a grant, so the client receives
+// paragraphs as they are written rather than one large body. The server
+// still parses the whole description before sending the first paragraph.
+func handleDescription(w http.ResponseWriter, r *http.Request) {
+	grantNumber := r.PathValue("number")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, canFlush := w.(http.Flusher)
+
+	encoder := json.NewEncoder(w)
+	err := service.StreamDescription(grantNumber, func(paragraph string) error {
+		if err := encoder.Encode(map[string]string{"text": paragraph}); err != nil {
+			return err
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("description %s: %v", grantNumber, err)
+		// Headers are likely already sent by the time streaming fails
+		// partway through, so there's nothing left to do but log it.
+	}
+}
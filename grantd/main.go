@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	_ "github.com/lib/pq"
+)
+
+// service is the single GrantService instance every handler calls into,
+// built once in main() - same top-level-var-for-shared-state pattern
+// prior_art_api uses for cfg.
+var service *GrantService
+
+func main() {
+	var (
+		addr      = flag.String("addr", cfg.ListenAddr, "HTTP listen address")
+		warm      = flag.Int("warm", cfg.WarmCount, "Pre-open the N most recently updated archives at startup")
+		cacheSize = flag.Int("cache-size", cfg.CacheSize, "Max number of open *zip.ReadCloser handles to keep in the LRU cache")
+	)
+	flag.Parse()
+
+	cfg.WarmCount = *warm
+	cfg.CacheSize = *cacheSize
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	log.Printf("grantd starting - archive root: %s, zip cache size: %d", cfg.ArchiveRoot, cfg.CacheSize)
+
+	connStr := fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=disable",
+		cfg.DBHost, cfg.DBPort, cfg.DBName, cfg.DBUser, cfg.DBPassword)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatalf("Database connection error: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Database ping failed: %v", err)
+	}
+	log.Println("Database connection established")
+
+	cache := newZipCache(cfg.CacheSize)
+	service = NewGrantService(db, cache, cfg.ArchiveRoot)
+
+	if cfg.WarmCount > 0 {
+		warmCache(db, cache, cfg.WarmCount)
+	}
+
+	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("GET /grants/{number}/claims", handleClaims)
+	http.HandleFunc("GET /grants/{number}/citations", handleCitations)
+	http.HandleFunc("GET /grants/{number}/abstract", handleAbstract)
+	http.HandleFunc("GET /grants/{number}/description", handleDescription)
+
+	log.Printf("Server listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+// warmCache pre-opens the n most recently updated archives so the first
+// request against today's ingest doesn't pay zip.OpenReader's
+// central-directory parse cost on the request path.
+func warmCache(db *sql.DB, cache *zipCache, n int) {
+	lookup := &grantSegmentLookup{db: db}
+	names, err := lookup.recentArchiveNames(n)
+	if err != nil {
+		log.Printf("warm: failed to list recent archives: %v", err)
+		return
+	}
+
+	for _, name := range names {
+		path := filepath.Join(cfg.ArchiveRoot, name)
+		if _, err := cache.get(path); err != nil {
+			log.Printf("warm: failed to open %s: %v", name, err)
+			continue
+		}
+		log.Printf("warm: pre-opened %s", name)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// grantSegmentLookup resolves a grant number to the exact archive entry and
+// byte range that holds it, via the patent_grant_segments manifest the main
+// grant_extractor binary maintains.
+type grantSegmentLookup struct {
+	db *sql.DB
+}
+
+// grantLocation is where one grant's raw XML lives: an archive name, the
+// entry inside it, and the byte range of its <us-patent-grant> element.
+type grantLocation struct {
+	ArchiveName string
+	EntryName   string
+	ByteOffset  int64
+	ByteLength  int64
+}
+
+func (l *grantSegmentLookup) find(grantNumber string) (grantLocation, error) {
+	var rawSource string
+	var loc grantLocation
+
+	err := l.db.QueryRow(`
+		SELECT raw_xml_source, byte_offset, byte_length
+		FROM patent_grant_segments
+		WHERE grant_number = $1
+	`, grantNumber).Scan(&rawSource, &loc.ByteOffset, &loc.ByteLength)
+	if err != nil {
+		return grantLocation{}, err
+	}
+
+	parts := strings.SplitN(rawSource, "/", 2)
+	if len(parts) != 2 {
+		return grantLocation{}, fmt.Errorf("malformed raw_xml_source %q", rawSource)
+	}
+	loc.ArchiveName, loc.EntryName = parts[0], parts[1]
+	return loc, nil
+}
+
+// recentArchiveNames returns the n archive names (e.g. "ipg250415.zip")
+// patent_grant_segments has seen the most recent updated_at for, for -warm
+// to pre-open at startup.
+func (l *grantSegmentLookup) recentArchiveNames(n int) ([]string, error) {
+	rows, err := l.db.Query(`
+		SELECT raw_xml_source, MAX(updated_at) AS last_update
+		FROM patent_grant_segments
+		GROUP BY raw_xml_source
+		ORDER BY last_update DESC
+		LIMIT $1
+	`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var rawSource string
+		var lastUpdate interface{}
+		if err := rows.Scan(&rawSource, &lastUpdate); err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(rawSource, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		names = append(names, parts[0])
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"container/list"
+	"sync"
+)
+
+// zipCache is an LRU of opened archive readers. Requests for grant
+// sub-documents tend to come in bursts against whatever archive was most
+// recently ingested, so keeping the last few *zip.ReadCloser handles open
+// avoids re-parsing a multi-GB archive's central directory on every lookup.
+type zipCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type zipCacheEntry struct {
+	path string
+	r    *zip.ReadCloser
+}
+
+func newZipCache(capacity int) *zipCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &zipCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns an open *zip.ReadCloser for path, opening and caching it if
+// it isn't already resident, and evicting the least-recently-used handle if
+// the cache is full.
+func (c *zipCache) get(path string) (*zip.ReadCloser, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[path]; ok {
+		c.order.MoveToFront(el)
+		r := el.Value.(*zipCacheEntry).r
+		c.mu.Unlock()
+		return r, nil
+	}
+	c.mu.Unlock()
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another goroutine may have opened and cached the same archive while
+	// we didn't hold the lock - keep theirs, close the redundant one.
+	if el, ok := c.entries[path]; ok {
+		r.Close()
+		c.order.MoveToFront(el)
+		return el.Value.(*zipCacheEntry).r, nil
+	}
+
+	el := c.order.PushFront(&zipCacheEntry{path: path, r: r})
+	c.entries[path] = el
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		evicted := oldest.Value.(*zipCacheEntry)
+		delete(c.entries, evicted.path)
+		evicted.r.Close()
+	}
+
+	return r, nil
+}
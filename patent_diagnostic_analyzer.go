@@ -1,21 +1,39 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"container/list"
+	"context"
 	"database/sql"
+	"encoding/binary"
 	"encoding/json"
+	"encoding/xml"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	_ "github.com/lib/pq"
+	"github.com/ulikunitz/xz"
 )
 
 type Config struct {
@@ -26,6 +44,11 @@ type Config struct {
 	DBPassword string
 	FilesRoot  string
 	LogFile    string
+	CacheSize  int
+	IndexPath  string
+
+	Workers        int
+	CheckpointFile string
 }
 
 var cfg = Config{
@@ -36,6 +59,11 @@ var cfg = Config{
 	DBPassword: "qwklmn711",
 	FilesRoot:  "/mnt/patents/originals",
 	LogFile:    "/home/mark/projects/patent_extractor/logs/diagnostic_analysis.jsonl",
+	CacheSize:  8,
+	IndexPath:  "/home/mark/projects/patent_extractor/patents.idx",
+
+	Workers:        runtime.NumCPU(),
+	CheckpointFile: "/home/mark/projects/patent_extractor/checkpoint.json",
 }
 
 type DiagnosticEntry struct {
@@ -94,6 +122,10 @@ func initDB() error {
 	cfg.DBPassword = getEnv("DB_PASSWORD", cfg.DBPassword)
 	cfg.FilesRoot = getEnv("FILES_ROOT", cfg.FilesRoot)
 	cfg.LogFile = getEnv("LOG_FILE", cfg.LogFile)
+	cfg.CacheSize = getEnvInt("ARCHIVE_CACHE_SIZE", cfg.CacheSize)
+	cfg.IndexPath = getEnv("PATENT_INDEX_PATH", cfg.IndexPath)
+	cfg.Workers = getEnvInt("DIAGNOSTIC_WORKERS", cfg.Workers)
+	cfg.CheckpointFile = getEnv("CHECKPOINT_FILE", cfg.CheckpointFile)
 
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
@@ -119,14 +151,178 @@ func initLogFile() error {
 	return err
 }
 
+// logChan is the single channel diagnosePatent's workers send completed
+// entries to; logWriter is the only goroutine that ever touches logFile, so
+// concurrent workers never interleave writes into the JSONL file.
+var logChan chan DiagnosticEntry
+
 func writeLog(entry DiagnosticEntry) {
 	entry.Timestamp = time.Now().Format(time.RFC3339)
-	data, err := json.Marshal(entry)
+	logChan <- entry
+}
+
+// logWriter drains logChan and is the sole writer of cfg.LogFile. It returns
+// once logChan is closed and drained, signaling done so callers can wait for
+// every in-flight entry to hit disk before closing the file.
+func logWriter(done chan<- struct{}) {
+	defer close(done)
+	for entry := range logChan {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Error marshaling log entry: %v", err)
+			continue
+		}
+		logFile.WriteString(string(data) + "\n")
+	}
+}
+
+// Checkpoint records the last (year, pub_number) pair the pipeline has fully
+// finished processing, so a restart can skip work it already did.
+type Checkpoint struct {
+	Year      int    `json:"year"`
+	PubNumber string `json:"pub_number"`
+}
+
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Printf("Error marshaling log entry: %v", err)
-		return
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func saveCheckpoint(path string, cp Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// patentJob is one row from the missing-patents query, tagged with the
+// sequence number it was dequeued in so checkpointTracker can tell which
+// prefix of the ordered query result has fully completed.
+type patentJob struct {
+	seq        int64
+	pubNumber  string
+	rawPath    string
+	year       int
+	filingDate string
+	pubDate    string
+}
+
+// checkpointTracker watches jobs complete out of order (workers race each
+// other) but only ever advances the on-disk checkpoint across a contiguous
+// completed prefix, so a crash never skips a job that hasn't actually
+// finished.
+type checkpointTracker struct {
+	mu      sync.Mutex
+	path    string
+	jobs    map[int64]patentJob
+	done    map[int64]bool
+	nextSeq int64
+	saved   Checkpoint
+}
+
+func newCheckpointTracker(path string) *checkpointTracker {
+	return &checkpointTracker{path: path, jobs: make(map[int64]patentJob), done: make(map[int64]bool)}
+}
+
+func (c *checkpointTracker) track(job patentJob) {
+	c.mu.Lock()
+	c.jobs[job.seq] = job
+	c.mu.Unlock()
+}
+
+func (c *checkpointTracker) complete(seq int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.done[seq] = true
+
+	advanced := false
+	for c.done[c.nextSeq] {
+		job := c.jobs[c.nextSeq]
+		c.saved = Checkpoint{Year: job.year, PubNumber: job.pubNumber}
+		delete(c.done, c.nextSeq)
+		delete(c.jobs, c.nextSeq)
+		c.nextSeq++
+		advanced = true
+	}
+
+	if advanced {
+		if err := saveCheckpoint(c.path, c.saved); err != nil {
+			log.Printf("checkpoint save failed: %v", err)
+		}
+	}
+}
+
+// produceJobs streams rows from the query into jobChan in order, registering
+// each one with tracker before handing it to a worker. It stops early if ctx
+// is canceled, leaving any unsent rows for the next run's checkpoint cursor
+// to pick back up.
+func produceJobs(ctx context.Context, rows *sql.Rows, jobChan chan<- patentJob, tracker *checkpointTracker) {
+	defer close(jobChan)
+
+	var seq int64
+	for rows.Next() {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		var pubNumber, rawPath string
+		var year int
+		var filingDate, pubDate *string
+
+		if err := rows.Scan(&pubNumber, &rawPath, &year, &filingDate, &pubDate); err != nil {
+			log.Printf("Scan error: %v", err)
+			continue
+		}
+
+		fd := ""
+		if filingDate != nil {
+			fd = *filingDate
+		}
+		pd := ""
+		if pubDate != nil {
+			pd = *pubDate
+		}
+
+		job := patentJob{seq: seq, pubNumber: pubNumber, rawPath: rawPath, year: year, filingDate: fd, pubDate: pd}
+		tracker.track(job)
+		seq++
+
+		select {
+		case jobChan <- job:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// diagnosticWorker is one of cfg.Workers parallel consumers of jobChan. It
+// calls diagnosePatent (which itself only ever touches logChan, never
+// logFile directly) and reports each job done to tracker for the checkpoint.
+func diagnosticWorker(jobChan <-chan patentJob, tracker *checkpointTracker, processed *int64, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for job := range jobChan {
+		diagnosePatent(job.pubNumber, job.rawPath, job.year, job.filingDate, job.pubDate)
+		tracker.complete(job.seq)
+
+		n := atomic.AddInt64(processed, 1)
+		if n%100 == 0 {
+			log.Printf("Analyzed %d patents...", n)
+		}
 	}
-	logFile.WriteString(string(data) + "\n")
 }
 
 func extractPubDate(path string) string {
@@ -136,25 +332,53 @@ func extractPubDate(path string) string {
 	return ""
 }
 
+// safeArchivePath normalizes an archive-derived path - a zip.File.Name out of
+// a central directory, or a raw_xml_path read from the database - before it's
+// used for matching. It rejects absolute paths and any ".." segment,
+// normalizes backslashes to forward slashes, and lower-cases the result.
+// Nothing here writes entries to disk yet, but callers that build a suffix
+// match out of attacker-reachable archive content should route it through
+// here first so a later refactor that does write them out isn't the one
+// stuck discovering this the hard way (the same class of bug Minio's
+// inspect-data handler patched by rejecting ".." and normalizing separators).
+func safeArchivePath(name string) (string, error) {
+	cleaned := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(cleaned, "/") {
+		return "", fmt.Errorf("archive path %q is absolute", name)
+	}
+	for _, seg := range strings.Split(cleaned, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("archive path %q contains a \"..\" segment", name)
+		}
+	}
+	return strings.ToLower(cleaned), nil
+}
+
+// archiveSuffixes lists the container extensions USPTO has shipped weekly
+// bundles under, tried in order. Historical distributions aren't always ZIP:
+// some mirrors re-package the same week as tar.xz or .tar.zst.
+var archiveSuffixes = []string{".ZIP", ".zip", ".tar.xz", ".tar.zst", ".tar.gz", ".tgz", ".tar"}
+
 func buildArchiveName(pubDate string) string {
 	if len(pubDate) == 8 {
-		return pubDate + ".ZIP"
+		return pubDate
 	}
 	return ""
 }
 
-func findArchive(archiveName string) (string, int64, error) {
-	// Try multiple archive name variations
-	// Some archives are split: 20030313.ZIP -> 20030313A.ZIP + 20030313B.ZIP
-	baseArchive := strings.TrimSuffix(archiveName, ".ZIP")
-
-	paths := []string{
-		filepath.Join(cfg.FilesRoot, archiveName),
-		filepath.Join(cfg.FilesRoot, "NewFiles", archiveName),
-		filepath.Join(cfg.FilesRoot, baseArchive+"A.ZIP"),
-		filepath.Join(cfg.FilesRoot, baseArchive+"B.ZIP"),
-		filepath.Join(cfg.FilesRoot, "NewFiles", baseArchive+"A.ZIP"),
-		filepath.Join(cfg.FilesRoot, "NewFiles", baseArchive+"B.ZIP"),
+func findArchive(archiveBase string) (string, int64, error) {
+	// Try every known suffix, and the split A/B variants some dates ship as
+	// (e.g. 20030313A.ZIP + 20030313B.ZIP), across both candidate directories.
+	var paths []string
+	for _, suffix := range archiveSuffixes {
+		paths = append(paths,
+			filepath.Join(cfg.FilesRoot, archiveBase+suffix),
+			filepath.Join(cfg.FilesRoot, "NewFiles", archiveBase+suffix),
+			filepath.Join(cfg.FilesRoot, archiveBase+"A"+suffix),
+			filepath.Join(cfg.FilesRoot, archiveBase+"B"+suffix),
+			filepath.Join(cfg.FilesRoot, "NewFiles", archiveBase+"A"+suffix),
+			filepath.Join(cfg.FilesRoot, "NewFiles", archiveBase+"B"+suffix),
+		)
 	}
 
 	for _, path := range paths {
@@ -166,77 +390,1004 @@ func findArchive(archiveName string) (string, int64, error) {
 	return "", 0, fmt.Errorf("archive not found")
 }
 
-func analyzeXMLContent(xmlData []byte) map[string]interface{} {
-	result := make(map[string]interface{})
+// ============================================================================
+// Magic-byte format dispatch: the outer container is usually a ZIP, but some
+// historical mirrors ship xz, zstd or gzip-wrapped tars of the same layout.
+// ============================================================================
+
+type archiveFormat int
+
+const (
+	formatUnknown archiveFormat = iota
+	formatZip
+	formatXz
+	formatZstd
+	formatGzip
+	formatTar
+)
+
+var (
+	magicXz   = []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A}
+	magicZstd = []byte{0x28, 0xB5, 0x2F, 0xFD}
+	magicZip  = []byte{0x50, 0x4B, 0x03, 0x04}
+	magicGzip = []byte{0x1F, 0x8B}
+)
+
+// probeFormat sniffs the first bytes of path to decide which decoder to use,
+// following the same magic-byte dispatch approach as Forgejo's arch-package
+// plugin.
+func probeFormat(path string) (archiveFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return formatUnknown, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return formatUnknown, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, magicXz):
+		return formatXz, nil
+	case bytes.HasPrefix(head, magicZstd):
+		return formatZstd, nil
+	case bytes.HasPrefix(head, magicZip):
+		return formatZip, nil
+	case bytes.HasPrefix(head, magicGzip):
+		return formatGzip, nil
+	}
+
+	// Bare (uncompressed) tar: ustar magic lives at offset 257.
+	if _, err := f.Seek(257, io.SeekStart); err == nil {
+		tarMagic := make([]byte, 5)
+		if n, _ := io.ReadFull(f, tarMagic); n == 5 && string(tarMagic) == "ustar" {
+			return formatTar, nil
+		}
+	}
+
+	return formatUnknown, nil
+}
+
+// archiveEntry describes one member of an outer archive, regardless of the
+// underlying container format.
+type archiveEntry struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
 
-	// Extract DTD version
-	if match := regexp.MustCompile(`<!DOCTYPE[^>]*SYSTEM\s+"([^"]+)"`).FindSubmatch(xmlData); len(match) > 1 {
-		result["dtd_version"] = string(match[1])
+// openArchiveEntries returns the member list of a non-ZIP outer archive
+// (xz/zstd/gzip-wrapped tar, or bare tar). ZIP archives keep using the
+// ArchiveCache path since that one is already central-directory-only; these
+// historical formats are rare enough that decompressing once per lookup is
+// an acceptable trade rather than building a second persistent cache for them.
+func openArchiveEntries(path string, format archiveFormat) ([]archiveEntry, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Check for various XML structures
-	result["has_application_reference"] = regexp.MustCompile(`<application-reference`).Match(xmlData)
-	result["has_domestic_filing_data"] = regexp.MustCompile(`<domestic-filing-data`).Match(xmlData)
-	result["has_application_number"] = regexp.MustCompile(`<application-number`).Match(xmlData)
-	result["has_doc_number"] = regexp.MustCompile(`<doc-number`).Match(xmlData)
+	var tr *tar.Reader
+	closer := func() error { return f.Close() }
 
-	// Try to extract raw application number section
-	if match := regexp.MustCompile(`(?is)<application-number[^>]*>(.*?)</application-number>`).FindSubmatch(xmlData); len(match) > 1 {
-		result["raw_app_number_text"] = strings.TrimSpace(string(match[1]))
-	} else if match := regexp.MustCompile(`(?is)<domestic-filing-data[^>]*>(.*?)</domestic-filing-data>`).FindSubmatch(xmlData); len(match) > 1 {
-		// Get application-number within domestic-filing-data
-		if appMatch := regexp.MustCompile(`(?is)<application-number[^>]*>(.*?)</application-number>`).FindSubmatch(match[1]); len(appMatch) > 1 {
-			result["raw_app_number_text"] = strings.TrimSpace(string(appMatch[1]))
+	switch format {
+	case formatXz:
+		xr, err := xz.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("xz: %w", err)
 		}
+		tr = tar.NewReader(xr)
+	case formatZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("zstd: %w", err)
+		}
+		tr = tar.NewReader(zr)
+		inner := closer
+		closer = func() error { zr.Close(); return inner() }
+	case formatGzip:
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("gzip: %w", err)
+		}
+		tr = tar.NewReader(gzr)
+	case formatTar:
+		tr = tar.NewReader(f)
+	default:
+		f.Close()
+		return nil, nil, fmt.Errorf("unsupported archive format")
 	}
 
-	// Get XML sample (first 2000 chars after DOCTYPE)
-	if idx := bytes.Index(xmlData, []byte("]>")); idx > 0 && len(xmlData) > idx+2000 {
-		result["xml_sample"] = string(xmlData[idx+2:idx+2000])
-	} else if len(xmlData) > 2000 {
-		result["xml_sample"] = string(xmlData[:2000])
-	} else {
-		result["xml_sample"] = string(xmlData)
+	var entries []archiveEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("reading tar entries: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := hdr.Name
+		size := hdr.Size
+		data := make([]byte, size)
+		if _, err := io.ReadFull(tr, data); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("reading tar entry %s: %w", name, err)
+		}
+		entries = append(entries, archiveEntry{
+			Name: name,
+			Open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(data)), nil
+			},
+		})
+	}
+
+	return entries, closer, nil
+}
+
+// ============================================================================
+// Archive cache: bounded LRU of opened outer archives, so a scan over many
+// patents from the same weekly ZIP only pays for the central directory parse
+// once instead of on every diagnosePatent call. Mirrors the buffer-cache
+// shape used by go-git's plumbing/cache (doubly linked list + map), with
+// eviction closing the underlying *os.File.
+// ============================================================================
+
+type archiveCacheEntry struct {
+	path string
+	file *os.File
+	zr   *zip.Reader
+	elem *list.Element
+}
+
+type ArchiveCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*archiveCacheEntry
+	order    *list.List // front = most recently used
+}
+
+func NewArchiveCache(capacity int) *ArchiveCache {
+	if capacity <= 0 {
+		capacity = 8
+	}
+	return &ArchiveCache{
+		capacity: capacity,
+		entries:  make(map[string]*archiveCacheEntry),
+		order:    list.New(),
+	}
+}
+
+// Get returns a *zip.Reader for path, opening and caching it on a miss via
+// os.Open + zip.NewReader(file, size) so only the central directory and the
+// requested entries are ever read.
+func (c *ArchiveCache) Get(path string) (*zip.Reader, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[path]; ok {
+		c.order.MoveToFront(entry.elem)
+		zr := entry.zr
+		c.mu.Unlock()
+		return zr, nil
+	}
+	c.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another goroutine may have raced us to a cached entry for this path.
+	if entry, ok := c.entries[path]; ok {
+		c.order.MoveToFront(entry.elem)
+		f.Close()
+		return entry.zr, nil
+	}
+
+	entry := &archiveCacheEntry{path: path, file: f, zr: zr}
+	entry.elem = c.order.PushFront(entry)
+	c.entries[path] = entry
+
+	if c.order.Len() > c.capacity {
+		lru := c.order.Back()
+		if lru != nil {
+			evicted := lru.Value.(*archiveCacheEntry)
+			c.order.Remove(lru)
+			delete(c.entries, evicted.path)
+			evicted.file.Close()
+		}
+	}
+
+	return zr, nil
+}
+
+// ============================================================================
+// Nested-ZIP cache: memoizes the decompressed bytes of the inner ZIP blob
+// keyed by (outer archive, nested ZIP name), since a given outer archive may
+// be asked for the same nested ZIP by several patents in the same directory.
+// ============================================================================
+
+type nestedZipKey struct {
+	archive string
+	name    string
+}
+
+type NestedZipCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[nestedZipKey]*list.Element
+	order    *list.List // front = most recently used
+}
+
+type nestedZipCacheEntry struct {
+	key  nestedZipKey
+	data []byte
+}
+
+func NewNestedZipCache(capacity int) *NestedZipCache {
+	if capacity <= 0 {
+		capacity = 32
+	}
+	return &NestedZipCache{
+		capacity: capacity,
+		entries:  make(map[nestedZipKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *NestedZipCache) Get(archive, name string) ([]byte, bool) {
+	key := nestedZipKey{archive, name}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*nestedZipCacheEntry).data, true
+}
+
+func (c *NestedZipCache) Put(archive, name string, data []byte) {
+	key := nestedZipKey{archive, name}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*nestedZipCacheEntry).data = data
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(&nestedZipCacheEntry{key: key, data: data})
+	c.entries[key] = elem
+
+	if c.order.Len() > c.capacity {
+		lru := c.order.Back()
+		if lru != nil {
+			evicted := lru.Value.(*nestedZipCacheEntry)
+			c.order.Remove(lru)
+			delete(c.entries, evicted.key)
+		}
+	}
+}
+
+var archiveCache *ArchiveCache
+var nestedZipCache *NestedZipCache
+
+// ============================================================================
+// Persistent on-disk patent index: borrowed from the document-name -> offset
+// lookup dbxml builds for its .dact archives. A one-time parallel walk over
+// every outer ZIP under cfg.FilesRoot records exactly where each patent's XML
+// lives (outer archive, nested ZIP, XML entry), so a later lookup is a binary
+// search plus two targeted decompressions (the nested ZIP member, then the
+// XML entry inside it) instead of re-parsing two ZIP
+// central directories per patent. Scoped to ZIP outer archives only: the
+// xz/zstd/tar formats added above aren't random-access friendly the same way,
+// so BuildIndex simply skips them and they keep going through diagnosePatent's
+// normal archive-walk path.
+// ============================================================================
+
+const (
+	indexMagic      = "PATIDX01"
+	indexVersion    = uint32(1)
+	indexHeaderSize = 8 + 4 + 4 + 4 // magic + version + record count + crc32(body)
+
+	idxPubNumberLen = 20
+	idxPathLen      = 256
+	idxEntryNameLen = 128
+	idxDTDLen       = 16
+	idxRecordSize   = idxPubNumberLen + idxPathLen + idxEntryNameLen*2 + idxDTDLen + 8*6 + 2*2
+)
+
+// dtdVersionRe mirrors the DOCTYPE SYSTEM extraction in classifyDoctype.
+var dtdVersionRe = regexp.MustCompile(`<!DOCTYPE[^>]*SYSTEM\s+"([^"]+)"`)
+var pubNumberFromNameRe = regexp.MustCompile(`US(\d+)`)
+
+// IndexRecord is one fixed-width entry in patents.idx: everything LookupXML
+// needs to go straight from a pub_number to compressed XML bytes without
+// walking any ZIP central directory.
+type IndexRecord struct {
+	PubNumber           string
+	OuterArchivePath    string
+	NestedZipName       string
+	NestedZipOffset     int64
+	NestedZipCompSize   int64
+	NestedZipUncompSize int64
+	NestedZipMethod     uint16
+	XMLEntryName        string
+	XMLOffset           int64
+	XMLCompSize         int64
+	XMLUncompSize       int64
+	XMLMethod           uint16
+	DTDVersion          string
+}
+
+func putFixedString(dst []byte, s string, width int) error {
+	if len(s) > width {
+		return fmt.Errorf("value %q exceeds fixed field width %d", s, width)
+	}
+	for i := range dst[:width] {
+		dst[i] = 0
+	}
+	copy(dst, s)
+	return nil
+}
+
+func encodeIndexRecord(r IndexRecord) ([]byte, error) {
+	buf := make([]byte, idxRecordSize)
+	o := 0
+
+	for _, f := range []struct {
+		val   string
+		width int
+	}{
+		{r.PubNumber, idxPubNumberLen},
+		{r.OuterArchivePath, idxPathLen},
+		{r.NestedZipName, idxEntryNameLen},
+		{r.XMLEntryName, idxEntryNameLen},
+		{r.DTDVersion, idxDTDLen},
+	} {
+		if err := putFixedString(buf[o:o+f.width], f.val, f.width); err != nil {
+			return nil, err
+		}
+		o += f.width
+	}
+
+	for _, v := range []int64{
+		r.NestedZipOffset, r.NestedZipCompSize, r.NestedZipUncompSize,
+		r.XMLOffset, r.XMLCompSize, r.XMLUncompSize,
+	} {
+		binary.BigEndian.PutUint64(buf[o:], uint64(v))
+		o += 8
+	}
+
+	binary.BigEndian.PutUint16(buf[o:], r.NestedZipMethod)
+	o += 2
+	binary.BigEndian.PutUint16(buf[o:], r.XMLMethod)
+	o += 2
+
+	return buf, nil
+}
+
+func decodeIndexRecord(buf []byte) IndexRecord {
+	o := 0
+	readString := func(width int) string {
+		s := string(bytes.TrimRight(buf[o:o+width], "\x00"))
+		o += width
+		return s
+	}
+
+	r := IndexRecord{
+		PubNumber:        readString(idxPubNumberLen),
+		OuterArchivePath: readString(idxPathLen),
+		NestedZipName:    readString(idxEntryNameLen),
+		XMLEntryName:     readString(idxEntryNameLen),
+		DTDVersion:       readString(idxDTDLen),
+	}
+
+	readInt64 := func() int64 {
+		v := int64(binary.BigEndian.Uint64(buf[o:]))
+		o += 8
+		return v
+	}
+	r.NestedZipOffset = readInt64()
+	r.NestedZipCompSize = readInt64()
+	r.NestedZipUncompSize = readInt64()
+	r.XMLOffset = readInt64()
+	r.XMLCompSize = readInt64()
+	r.XMLUncompSize = readInt64()
+
+	r.NestedZipMethod = binary.BigEndian.Uint16(buf[o:])
+	o += 2
+	r.XMLMethod = binary.BigEndian.Uint16(buf[o:])
+	o += 2
+
+	return r
+}
+
+// indexArchive opens a single outer ZIP and records every nested-ZIP/XML pair
+// it can find. Decompressing each nested ZIP here, once, is what lets
+// LookupXML later decompress only the one nested ZIP member and the one XML
+// entry it needs, without reading either archive's central directory.
+func indexArchive(path string) ([]IndexRecord, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var records []IndexRecord
+	for _, f := range zr.File {
+		safeOuterName, err := safeArchivePath(f.Name)
+		if err != nil {
+			continue
+		}
+		if !strings.HasSuffix(safeOuterName, ".zip") {
+			continue
+		}
+
+		nestedOffset, err := f.DataOffset()
+		if err != nil {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		nestedData, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			continue
+		}
+
+		nzr, err := zip.NewReader(bytes.NewReader(nestedData), int64(len(nestedData)))
+		if err != nil {
+			continue
+		}
+
+		for _, nf := range nzr.File {
+			safeNestedName, err := safeArchivePath(nf.Name)
+			if err != nil {
+				continue
+			}
+			if !strings.HasSuffix(safeNestedName, ".xml") {
+				continue
+			}
+			match := pubNumberFromNameRe.FindStringSubmatch(nf.Name)
+			if match == nil {
+				continue
+			}
+
+			xmlOffset, err := nf.DataOffset()
+			if err != nil {
+				continue
+			}
+
+			dtd := ""
+			if xrc, err := nf.Open(); err == nil {
+				head := make([]byte, 2000)
+				n, _ := io.ReadFull(xrc, head)
+				xrc.Close()
+				if dm := dtdVersionRe.FindSubmatch(head[:n]); len(dm) > 1 {
+					dtd = string(dm[1])
+				}
+			}
+
+			records = append(records, IndexRecord{
+				PubNumber:           match[1],
+				OuterArchivePath:    path,
+				NestedZipName:       f.Name,
+				NestedZipOffset:     nestedOffset,
+				NestedZipCompSize:   int64(f.CompressedSize64),
+				NestedZipUncompSize: int64(f.UncompressedSize64),
+				NestedZipMethod:     uint16(f.Method),
+				XMLEntryName:        nf.Name,
+				XMLOffset:           xmlOffset,
+				XMLCompSize:         int64(nf.CompressedSize64),
+				XMLUncompSize:       int64(nf.UncompressedSize64),
+				XMLMethod:           uint16(nf.Method),
+				DTDVersion:          dtd,
+			})
+		}
 	}
 
-	return result
+	return records, nil
 }
 
-func extractAppNumber(data []byte) string {
-	// Try new format (2005+)
-	appRefBlock := regexp.MustCompile(`(?is)<application-reference[^>]*>(.*?)</application-reference>`).FindSubmatch(data)
-	if len(appRefBlock) > 1 {
-		if match := regexp.MustCompile(`(?is)<doc-number[^>]*>([^<]+)</doc-number>`).FindSubmatch(appRefBlock[1]); len(match) > 1 {
-			raw := string(match[1])
-			return strings.Map(func(r rune) rune {
-				if r >= '0' && r <= '9' {
-					return r
+// BuildIndex walks every ZIP archive under root in parallel and writes a
+// sorted patents.idx to out.
+func BuildIndex(root, out string) error {
+	paths := make(chan string, 64)
+	results := make(chan []IndexRecord, 64)
+
+	workers := runtime.NumCPU()
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				recs, err := indexArchive(path)
+				if err != nil {
+					log.Printf("BuildIndex: skipping %s: %v", path, err)
+					continue
 				}
-				return -1
-			}, raw)
-		}
-	}
-
-	// Try old format (2001-2004)
-	domesticBlock := regexp.MustCompile(`(?is)<domestic-filing-data[^>]*>(.*?)</domestic-filing-data>`).FindSubmatch(data)
-	if len(domesticBlock) > 1 {
-		appNumBlock := regexp.MustCompile(`(?is)<application-number[^>]*>(.*?)</application-number>`).FindSubmatch(domesticBlock[1])
-		if len(appNumBlock) > 1 {
-			if match := regexp.MustCompile(`(?is)<doc-number[^>]*>([^<]+)</doc-number>`).FindSubmatch(appNumBlock[1]); len(match) > 1 {
-				raw := string(match[1])
-				return strings.Map(func(r rune) rune {
-					if r >= '0' && r <= '9' {
-						return r
-					}
-					return -1
-				}, raw)
+				results <- recs
 			}
+		}()
+	}
+
+	go func() {
+		defer close(paths)
+		filepath.Walk(root, func(path string, info os.FileInfo, walkErr error) error {
+			if walkErr != nil || info == nil || info.IsDir() {
+				return nil
+			}
+			if strings.HasSuffix(strings.ToUpper(path), ".ZIP") {
+				paths <- path
+			}
+			return nil
+		})
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var all []IndexRecord
+	for recs := range results {
+		all = append(all, recs...)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].PubNumber < all[j].PubNumber })
+
+	return writeIndexFile(out, all)
+}
+
+func writeIndexFile(out string, records []IndexRecord) error {
+	body := make([]byte, 0, len(records)*idxRecordSize)
+	for _, r := range records {
+		rec, err := encodeIndexRecord(r)
+		if err != nil {
+			return fmt.Errorf("encoding record for pub_number %s: %w", r.PubNumber, err)
+		}
+		body = append(body, rec...)
+	}
+
+	header := make([]byte, indexHeaderSize)
+	copy(header, []byte(indexMagic))
+	binary.BigEndian.PutUint32(header[8:], indexVersion)
+	binary.BigEndian.PutUint32(header[12:], uint32(len(records)))
+	binary.BigEndian.PutUint32(header[16:], crc32.ChecksumIEEE(body))
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		return err
+	}
+
+	log.Printf("BuildIndex: wrote %d records to %s", len(records), out)
+	return nil
+}
+
+var (
+	indexOnce sync.Once
+	indexData []byte
+	indexErr  error
+)
+
+// loadIndex mmaps patents.idx once per process and validates its header
+// (magic, version, record-count/body-length agreement, and a CRC32 of the
+// body so truncation or corruption is caught before a bad offset is trusted).
+func loadIndex(path string) ([]byte, error) {
+	indexOnce.Do(func() {
+		f, err := os.Open(path)
+		if err != nil {
+			indexErr = err
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			indexErr = err
+			return
+		}
+
+		data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			indexErr = fmt.Errorf("mmap %s: %w", path, err)
+			return
+		}
+
+		if len(data) < indexHeaderSize || string(data[:8]) != indexMagic {
+			indexErr = fmt.Errorf("%s: bad magic, index may be corrupt or stale", path)
+			return
+		}
+		if v := binary.BigEndian.Uint32(data[8:12]); v != indexVersion {
+			indexErr = fmt.Errorf("%s: unsupported index version %d", path, v)
+			return
+		}
+
+		count := binary.BigEndian.Uint32(data[12:16])
+		wantCRC := binary.BigEndian.Uint32(data[16:20])
+		body := data[indexHeaderSize:]
+		if uint64(len(body)) != uint64(count)*uint64(idxRecordSize) {
+			indexErr = fmt.Errorf("%s: record count does not match body length", path)
+			return
+		}
+		if crc32.ChecksumIEEE(body) != wantCRC {
+			indexErr = fmt.Errorf("%s: CRC32 mismatch, index is corrupt", path)
+			return
+		}
+
+		indexData = data
+	})
+	return indexData, indexErr
+}
+
+func indexRecordAt(data []byte, i int) IndexRecord {
+	off := indexHeaderSize + i*idxRecordSize
+	return decodeIndexRecord(data[off : off+idxRecordSize])
+}
+
+// decompressEntryAt reads and, if necessary, inflates exactly one ZIP entry's
+// bytes via io.ReaderAt + io.SectionReader, without touching anything else in
+// the surrounding archive.
+func decompressEntryAt(ra io.ReaderAt, offset, compSize, uncompSize int64, method uint16) ([]byte, error) {
+	sr := io.NewSectionReader(ra, offset, compSize)
+
+	switch method {
+	case uint16(zip.Store):
+		data := make([]byte, uncompSize)
+		if _, err := io.ReadFull(sr, data); err != nil {
+			return nil, err
 		}
+		return data, nil
+	case uint16(zip.Deflate):
+		fr := flate.NewReader(sr)
+		defer fr.Close()
+		return ioutil.ReadAll(fr)
+	default:
+		return nil, fmt.Errorf("unsupported compression method %d", method)
+	}
+}
+
+// LookupXML binary-searches patents.idx for pubNumber and, on a hit, opens
+// the outer archive with io.ReaderAt and decompresses only the targeted
+// nested ZIP and XML entry — no central directory parsing required.
+func LookupXML(pubNumber string) ([]byte, error) {
+	data, err := loadIndex(cfg.IndexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	count := (len(data) - indexHeaderSize) / idxRecordSize
+	i := sort.Search(count, func(i int) bool {
+		return indexRecordAt(data, i).PubNumber >= pubNumber
+	})
+	if i >= count {
+		return nil, fmt.Errorf("pub_number %s not found in index", pubNumber)
+	}
+	rec := indexRecordAt(data, i)
+	if rec.PubNumber != pubNumber {
+		return nil, fmt.Errorf("pub_number %s not found in index", pubNumber)
+	}
+
+	outer, err := os.Open(rec.OuterArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("opening outer archive: %w", err)
+	}
+	defer outer.Close()
+
+	nestedData, err := decompressEntryAt(outer, rec.NestedZipOffset, rec.NestedZipCompSize, rec.NestedZipUncompSize, rec.NestedZipMethod)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing nested zip %s: %w", rec.NestedZipName, err)
+	}
+
+	xmlData, err := decompressEntryAt(bytes.NewReader(nestedData), rec.XMLOffset, rec.XMLCompSize, rec.XMLUncompSize, rec.XMLMethod)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing xml entry %s: %w", rec.XMLEntryName, err)
+	}
+
+	return xmlData, nil
+}
+
+// ============================================================================
+// patentxml: a streaming, multi-DTD XML decoder. This repo's root scripts
+// have no go.mod to import a real sub-package from, so it lives inline here,
+// but it's named and shaped the way an importable patentxml package would
+// be - Patent is the unified result type, Decode is the entry point, and
+// DecodeError is a structured error naming the offending token so callers
+// can record a precise FailureReason instead of a coarse catch-all.
+//
+// Rather than maintaining three separate struct trees for the three DTD
+// vintages (us-patent-grant v4.x, patent-application-publication v4.x, and
+// the pre-2005 PAP format), Decode reads the <!DOCTYPE> once to pick a
+// tag-path table, then walks the rest of the document in token mode
+// filling in Patent fields whenever the current element stack's tail
+// matches one of that table's paths. The three vintages mostly differ in
+// where the doc-number and title live, which a path table captures just as
+// well as three struct trees would, for a fraction of the code.
+// ============================================================================
+
+// Patent is the unified result of decoding a single patent XML document,
+// regardless of which DTD vintage produced it.
+type Patent struct {
+	ApplicationNumber string
+	PublicationNumber string
+	FilingDate        string
+	PubDate           string
+	Title             string
+	Abstract          string
+	Figures           []string
+	DTDVersion        string
+}
+
+// DecodeError names the offending token alongside a short, machine-readable
+// FailureReason so a caller like diagnosePatent can log precisely why a
+// document didn't decode instead of a generic "extraction_failed_unknown".
+type DecodeError struct {
+	Token         string
+	FailureReason string
+	Err           error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s (at %s): %v", e.FailureReason, e.Token, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// usptoEntities pre-populates the SGML entities USPTO's pre-2005 DTDs
+// reference but frequently leave undeclared, so Decode can tolerate them
+// with Strict=false instead of failing the whole document over one entity.
+var usptoEntities = map[string]string{
+	"agr": "\u0386", "eacute": "\u00E9", "egrave": "\u00E8", "ecirc": "\u00EA",
+	"uuml": "\u00FC", "ouml": "\u00F6", "auml": "\u00E4", "ntilde": "\u00F1",
+	"deg": "\u00B0", "plusmn": "\u00B1", "sect": "\u00A7", "para": "\u00B6",
+	"middot": "\u00B7", "rsquo": "\u2019", "lsquo": "\u2018", "rdquo": "\u201D",
+	"ldquo": "\u201C", "hellip": "\u2026", "mdash": "\u2014", "ndash": "\u2013",
+}
+
+// patentXMLPaths maps each recognized DTD kind to the element-stack suffixes
+// that identify each Patent field. Paths are matched against the tail of the
+// stack rather than from the document root, since the exact ancestry above
+// these tags (e.g. which wrapper element the DTD roots everything under)
+// varies more than the tags themselves do.
+var patentXMLPaths = map[string]map[string][]string{
+	"grant": {
+		"pubNumber":  {"publication-reference", "document-id", "doc-number"},
+		"appNumber":  {"application-reference", "document-id", "doc-number"},
+		"filingDate": {"application-reference", "document-id", "date"},
+		"pubDate":    {"publication-reference", "document-id", "date"},
+		"title":      {"us-bibliographic-data-grant", "invention-title"},
+		"abstract":   {"abstract", "p"},
+	},
+	"apppub": {
+		"pubNumber":  {"publication-reference", "document-id", "doc-number"},
+		"appNumber":  {"application-reference", "document-id", "doc-number"},
+		"filingDate": {"application-reference", "document-id", "date"},
+		"pubDate":    {"publication-reference", "document-id", "date"},
+		"title":      {"us-bibliographic-data-application", "invention-title"},
+		"abstract":   {"abstract", "p"},
+	},
+	"pap": {
+		"appNumber":  {"domestic-filing-data", "application-number", "doc-number"},
+		"filingDate": {"domestic-filing-data", "filing-date"},
+		"title":      {"title-of-invention"},
+		"abstract":   {"sdoab", "p"},
+	},
+}
+
+func identityCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	return input, nil
+}
+
+// classifyDoctype inspects a <!DOCTYPE> directive and returns which path
+// table to use, plus the DTD's SYSTEM literal for diagnostics. Anything that
+// doesn't declare a recognizable us-patent-grant/us-patent-application root
+// falls back to the legacy "pap" table, since the pre-2005 PAP format is the
+// only vintage old enough to commonly omit one.
+func classifyDoctype(directive string) (kind, version string) {
+	if m := regexp.MustCompile(`SYSTEM\s+"([^"]+)"`).FindStringSubmatch(directive); len(m) > 1 {
+		version = m[1]
+	}
+	switch {
+	case strings.Contains(directive, "us-patent-grant"):
+		return "grant", version
+	case strings.Contains(directive, "us-patent-application"):
+		return "apppub", version
+	default:
+		return "pap", version
 	}
+}
 
+func matchPath(stack []string, paths map[string][]string) string {
+	for field, path := range paths {
+		if len(stack) < len(path) {
+			continue
+		}
+		tail := stack[len(stack)-len(path):]
+		match := true
+		for i, name := range path {
+			if tail[i] != name {
+				match = false
+				break
+			}
+		}
+		if match {
+			return field
+		}
+	}
 	return ""
 }
 
+func assignPatentField(p *Patent, field, value string) {
+	switch field {
+	case "appNumber":
+		p.ApplicationNumber = value
+	case "pubNumber":
+		p.PublicationNumber = value
+	case "filingDate":
+		p.FilingDate = value
+	case "pubDate":
+		p.PubDate = value
+	case "title":
+		p.Title = value
+	case "abstract":
+		if p.Abstract != "" {
+			p.Abstract += " "
+		}
+		p.Abstract += value
+	}
+}
+
+// cleanXMLText strips embedded tags, null bytes, and control characters from
+// a single extracted field. Decode applies this per-field as each element
+// closes, rather than once over the whole XML blob.
+func cleanXMLText(s string) string {
+	s = regexp.MustCompile(`<[^>]+>`).ReplaceAllString(s, " ")
+	s = strings.ReplaceAll(s, "\x00", "")
+	s = strings.Map(func(r rune) rune {
+		if r < 32 && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	s = regexp.MustCompile(`\s+`).ReplaceAllString(s, " ")
+	return strings.TrimSpace(s)
+}
+
+// Decode reads one patent XML document and returns a unified Patent struct.
+// It dispatches on the leading <!DOCTYPE> to pick a tag-path table, then
+// walks the rest of the document in token mode. Strict is disabled and a set
+// of common USPTO SGML entities is pre-registered so a malformed or
+// undeclared-entity document (common pre-2005) doesn't abort the whole
+// decode - a genuine parse failure still comes back as a *DecodeError naming
+// the element stack at the point of failure.
+func Decode(r io.Reader) (*Patent, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.Entity = usptoEntities
+	dec.CharsetReader = identityCharsetReader
+
+	patent := &Patent{}
+	var dtdKind string
+	var paths map[string][]string
+	var stack []string
+	var capturing string
+	var captureDepth int
+	var captureBuf strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return patent, &DecodeError{
+				Token:         strings.Join(stack, "/"),
+				FailureReason: "xml_token_error",
+				Err:           err,
+			}
+		}
+
+		switch t := tok.(type) {
+		case xml.Directive:
+			if dtdKind == "" && strings.Contains(string(t), "DOCTYPE") {
+				dtdKind, patent.DTDVersion = classifyDoctype(string(t))
+				paths = patentXMLPaths[dtdKind]
+			}
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			if paths != nil && capturing == "" {
+				if field := matchPath(stack, paths); field != "" {
+					capturing = field
+					captureDepth = len(stack)
+					captureBuf.Reset()
+				}
+			}
+		case xml.CharData:
+			if capturing != "" {
+				captureBuf.Write(t)
+			}
+		case xml.EndElement:
+			// Only finalize when the stack has unwound back to the element
+			// that started the capture - an inline child's EndElement (e.g.
+			// <sub>, <sup>, <b>, <i>) always matches the stack top too, so
+			// comparing names alone truncated title/abstract at the first
+			// nested tag. Mirrors captureText's depth counter in
+			// patent_extractor.go.
+			if capturing != "" && len(stack) == captureDepth {
+				assignPatentField(patent, capturing, cleanXMLText(captureBuf.String()))
+				capturing = ""
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	if dtdKind == "" {
+		return patent, &DecodeError{
+			FailureReason: "unrecognized_dtd",
+			Err:           fmt.Errorf("no <!DOCTYPE> declaration found"),
+		}
+	}
+
+	return patent, nil
+}
+
+func digitsOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
 func diagnosePatent(pubNumber, rawPath string, year int, filingDate, pubDate string) {
 	entry := DiagnosticEntry{
 		PubNumber:  pubNumber,
@@ -247,6 +1398,17 @@ func diagnosePatent(pubNumber, rawPath string, year int, filingDate, pubDate str
 		ErrorDetails: []string{},
 	}
 
+	// Step 0: Reject a database-supplied raw_xml_path before it's used to
+	// build any match target below.
+	safeRawPath, err := safeArchivePath(rawPath)
+	if err != nil {
+		entry.FailureReason = "unsafe_raw_xml_path"
+		entry.ErrorDetails = append(entry.ErrorDetails, err.Error())
+		writeLog(entry)
+		return
+	}
+	rawPath = safeRawPath
+
 	// Step 1: Extract archive name
 	extractedDate := extractPubDate(rawPath)
 	if extractedDate == "" {
@@ -271,45 +1433,95 @@ func diagnosePatent(pubNumber, rawPath string, year int, filingDate, pubDate str
 	entry.ArchiveFound = true
 	entry.ArchiveLocation = archivePath
 	entry.ArchiveSize = archiveSize
+	entry.ArchiveName = filepath.Base(archivePath)
+
+	// Step 3: Probe the outer archive's magic bytes and open it with the
+	// matching decoder. ZIP (the common case) goes through the LRU cache;
+	// xz/zstd/gzip-wrapped tars and bare tars are decoded on demand.
+	targetFile := filepath.Base(rawPath)
+	targetDir := filepath.Dir(rawPath)
+	// targetDir comes from rawPath, which safeArchivePath above already
+	// lower-cased, and is matched below against safeName (also lower-cased
+	// by safeArchivePath) - so the suffix must be lowercase too.
+	targetZip := targetDir + ".zip"
+	entry.NestedZipName = targetZip
 
-	// Step 3: Load and parse archive
-	archiveData, err := ioutil.ReadFile(archivePath)
+	format, err := probeFormat(archivePath)
 	if err != nil {
 		entry.FailureReason = "archive_read_error"
-		entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to read archive: %v", err))
+		entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to probe archive format: %v", err))
 		writeLog(entry)
 		return
 	}
 
-	zr, err := zip.NewReader(bytes.NewReader(archiveData), int64(len(archiveData)))
-	if err != nil {
-		entry.FailureReason = "archive_parse_error"
-		entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to parse ZIP: %v", err))
-		writeLog(entry)
-		return
-	}
+	var nestedZipData []byte
 
 	// Step 4: Find nested ZIP
-	targetFile := filepath.Base(rawPath)
-	targetDir := filepath.Dir(rawPath)
-	targetZip := targetDir + ".ZIP"
-	entry.NestedZipName = targetZip
-
-	var nestedZipData []byte
-	for _, f := range zr.File {
-		if strings.HasSuffix(strings.ToUpper(f.Name), targetZip) {
-			entry.NestedZipFound = true
-			rc, err := f.Open()
+	if cached, ok := nestedZipCache.Get(archivePath, targetZip); ok {
+		entry.NestedZipFound = true
+		nestedZipData = cached
+	} else if format == formatZip {
+		zr, err := archiveCache.Get(archivePath)
+		if err != nil {
+			entry.FailureReason = "archive_read_error"
+			entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to open archive: %v", err))
+			writeLog(entry)
+			return
+		}
+		for _, f := range zr.File {
+			safeName, err := safeArchivePath(f.Name)
 			if err != nil {
-				entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to open nested ZIP: %v", err))
+				entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Skipping unsafe archive entry: %v", err))
 				continue
 			}
-			nestedZipData, err = ioutil.ReadAll(rc)
-			rc.Close()
+			if strings.HasSuffix(safeName, targetZip) {
+				entry.NestedZipFound = true
+				rc, err := f.Open()
+				if err != nil {
+					entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to open nested ZIP: %v", err))
+					continue
+				}
+				nestedZipData, err = ioutil.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to read nested ZIP: %v", err))
+				} else {
+					nestedZipCache.Put(archivePath, targetZip, nestedZipData)
+				}
+				break
+			}
+		}
+	} else {
+		entries, closeArchive, err := openArchiveEntries(archivePath, format)
+		if err != nil {
+			entry.FailureReason = "archive_parse_error"
+			entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to open archive: %v", err))
+			writeLog(entry)
+			return
+		}
+		defer closeArchive()
+		for _, e := range entries {
+			safeName, err := safeArchivePath(e.Name)
 			if err != nil {
-				entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to read nested ZIP: %v", err))
+				entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Skipping unsafe archive entry: %v", err))
+				continue
+			}
+			if strings.HasSuffix(safeName, targetZip) {
+				entry.NestedZipFound = true
+				rc, err := e.Open()
+				if err != nil {
+					entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to open nested ZIP: %v", err))
+					continue
+				}
+				nestedZipData, err = ioutil.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Failed to read nested ZIP: %v", err))
+				} else {
+					nestedZipCache.Put(archivePath, targetZip, nestedZipData)
+				}
+				break
 			}
-			break
 		}
 	}
 
@@ -332,7 +1544,12 @@ func diagnosePatent(pubNumber, rawPath string, year int, filingDate, pubDate str
 	entry.XMLFileName = targetFile
 	var xmlData []byte
 	for _, nf := range nestedZr.File {
-		if strings.HasSuffix(nf.Name, targetFile) {
+		safeName, err := safeArchivePath(nf.Name)
+		if err != nil {
+			entry.ErrorDetails = append(entry.ErrorDetails, fmt.Sprintf("Skipping unsafe archive entry: %v", err))
+			continue
+		}
+		if strings.HasSuffix(safeName, targetFile) {
 			entry.XMLFileFound = true
 			entry.XMLSize = int64(nf.UncompressedSize64)
 
@@ -365,33 +1582,36 @@ func diagnosePatent(pubNumber, rawPath string, year int, filingDate, pubDate str
 		return
 	}
 
-	// Step 6: Analyze XML content
-	analysis := analyzeXMLContent(xmlData)
+	// Step 6: Decode via the patentxml multi-DTD token-mode parser
+	patent, decodeErr := Decode(bytes.NewReader(xmlData))
 
-	if dtd, ok := analysis["dtd_version"].(string); ok {
-		entry.DTDVersion = dtd
-	}
-	if val, ok := analysis["has_application_reference"].(bool); ok {
-		entry.HasApplicationRef = val
-	}
-	if val, ok := analysis["has_domestic_filing_data"].(bool); ok {
-		entry.HasDomesticFiling = val
-	}
-	if val, ok := analysis["has_application_number"].(bool); ok {
-		entry.HasAppNumber = val
-	}
-	if val, ok := analysis["has_doc_number"].(bool); ok {
-		entry.HasDocNumber = val
-	}
-	if val, ok := analysis["raw_app_number_text"].(string); ok {
-		entry.RawAppNumberText = val
+	entry.DTDVersion = patent.DTDVersion
+	entry.HasApplicationRef = bytes.Contains(xmlData, []byte("<application-reference"))
+	entry.HasDomesticFiling = bytes.Contains(xmlData, []byte("<domestic-filing-data"))
+	entry.HasAppNumber = bytes.Contains(xmlData, []byte("<application-number"))
+	entry.HasDocNumber = bytes.Contains(xmlData, []byte("<doc-number"))
+	entry.RawAppNumberText = patent.ApplicationNumber
+	if idx := bytes.Index(xmlData, []byte("]>")); idx > 0 && len(xmlData) > idx+2000 {
+		entry.XMLSample = string(xmlData[idx+2 : idx+2000])
+	} else if len(xmlData) > 2000 {
+		entry.XMLSample = string(xmlData[:2000])
+	} else {
+		entry.XMLSample = string(xmlData)
 	}
-	if val, ok := analysis["xml_sample"].(string); ok {
-		entry.XMLSample = val
+
+	if decodeErr != nil {
+		if de, ok := decodeErr.(*DecodeError); ok {
+			entry.FailureReason = de.FailureReason
+		} else {
+			entry.FailureReason = "xml_decode_error"
+		}
+		entry.ErrorDetails = append(entry.ErrorDetails, decodeErr.Error())
+		writeLog(entry)
+		return
 	}
 
-	// Step 7: Try to extract application number
-	appNum := extractAppNumber(xmlData)
+	// Step 7: Extract application number (digits only, matching the DB column)
+	appNum := digitsOnly(patent.ApplicationNumber)
 	entry.ExtractedAppNumber = appNum
 
 	// Determine failure reason
@@ -416,20 +1636,67 @@ func diagnosePatent(pubNumber, rawPath string, year int, filingDate, pubDate str
 
 func main() {
 	log.SetOutput(os.Stdout)
+
+	if len(os.Args) > 1 && os.Args[1] == "build-index" {
+		cfg.FilesRoot = getEnv("FILES_ROOT", cfg.FilesRoot)
+		cfg.IndexPath = getEnv("PATENT_INDEX_PATH", cfg.IndexPath)
+		log.Printf("Building patent index from %s into %s ...", cfg.FilesRoot, cfg.IndexPath)
+		if err := BuildIndex(cfg.FilesRoot, cfg.IndexPath); err != nil {
+			log.Fatalf("BuildIndex failed: %v", err)
+		}
+		return
+	}
+
+	flag.IntVar(&cfg.Workers, "workers", cfg.Workers, "Number of parallel diagnostic workers")
+	flag.StringVar(&cfg.CheckpointFile, "checkpoint", cfg.CheckpointFile, "Path to the resumable checkpoint file")
+	flag.Parse()
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+
 	log.Println("Starting Patent Diagnostic Analyzer...")
 	log.Printf("Log output: %s", cfg.LogFile)
+	log.Printf("Workers: %d, checkpoint: %s", cfg.Workers, cfg.CheckpointFile)
 
 	if err := initDB(); err != nil {
 		log.Fatalf("DB init failed: %v", err)
 	}
 	defer db.Close()
+	db.SetMaxOpenConns(cfg.Workers)
 
 	if err := initLogFile(); err != nil {
 		log.Fatalf("Log file init failed: %v", err)
 	}
 	defer logFile.Close()
 
-	// Query missing patents
+	archiveCache = NewArchiveCache(cfg.CacheSize)
+	nestedZipCache = NewNestedZipCache(cfg.CacheSize * 4)
+
+	logChan = make(chan DiagnosticEntry, cfg.Workers*4)
+	writerDone := make(chan struct{})
+	go logWriter(writerDone)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutdown signal received, finishing in-flight work and flushing checkpoint...")
+		cancel()
+	}()
+
+	cp, err := loadCheckpoint(cfg.CheckpointFile)
+	if err != nil {
+		log.Printf("Could not read checkpoint %s, starting from the beginning: %v", cfg.CheckpointFile, err)
+	}
+	cpYear, cpPubNumber := 0, ""
+	if cp != nil {
+		cpYear, cpPubNumber = cp.Year, cp.PubNumber
+		log.Printf("Resuming after checkpoint: year=%d pub_number=%s", cpYear, cpPubNumber)
+	}
+
+	// Query missing patents. The (year, pub_number) > ($1, $2) row comparison
+	// is what lets a restart skip everything the checkpoint already covers.
 	query := `
 		SELECT pub_number, raw_xml_path, year, filing_date, pub_date
 		FROM patent_data_unified
@@ -437,47 +1704,36 @@ func main() {
 		  AND raw_xml_path IS NOT NULL
 		  AND raw_xml_path != ''
 		  AND year IN (2001, 2002, 2003, 2004, 2010)
+		  AND (year, pub_number) > ($1, $2)
 		ORDER BY year, pub_number
 		LIMIT 1000
 	`
 
 	log.Println("Querying database for missing patents...")
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, cpYear, cpPubNumber)
 	if err != nil {
 		log.Fatalf("Query failed: %v", err)
 	}
 	defer rows.Close()
 
-	count := 0
-	for rows.Next() {
-		var pubNumber, rawPath string
-		var year int
-		var filingDate, pubDate *string
+	tracker := newCheckpointTracker(cfg.CheckpointFile)
+	jobChan := make(chan patentJob, cfg.Workers*4)
+	var processed int64
+	var wg sync.WaitGroup
 
-		if err := rows.Scan(&pubNumber, &rawPath, &year, &filingDate, &pubDate); err != nil {
-			log.Printf("Scan error: %v", err)
-			continue
-		}
-
-		fd := ""
-		if filingDate != nil {
-			fd = *filingDate
-		}
-		pd := ""
-		if pubDate != nil {
-			pd = *pubDate
-		}
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go diagnosticWorker(jobChan, tracker, &processed, &wg)
+	}
 
-		count++
-		if count%100 == 0 {
-			log.Printf("Analyzed %d patents...", count)
-		}
+	produceJobs(ctx, rows, jobChan, tracker)
+	wg.Wait()
 
-		diagnosePatent(pubNumber, rawPath, year, fd, pd)
-	}
+	close(logChan)
+	<-writerDone
 
 	log.Printf("\n=== Diagnostic Analysis Complete ===")
-	log.Printf("Analyzed: %d patents", count)
+	log.Printf("Analyzed: %d patents", atomic.LoadInt64(&processed))
 	log.Printf("Results written to: %s", cfg.LogFile)
 	log.Println("\nTo analyze results, use:")
 	log.Printf("  jq '.failure_reason' %s | sort | uniq -c", cfg.LogFile)
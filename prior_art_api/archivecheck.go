@@ -0,0 +1,408 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Archive integrity verification: builds a JSON manifest of every ZIP member
+// (and its interior XML/TIF entries) a year's TAR archives are supposed to
+// contain, then on later runs diffs the current archive state against it -
+// mirroring the target-files comparator's path/size/CRC diffing, but for the
+// ZIP-inside-TAR layout this binary serves from. Reuses parseArchivePath's
+// TAR-naming convention implicitly via listTarFiles (searchindex.go).
+// ============================================================================
+
+const (
+	manifestDirName = ".manifest"
+	manifestVersion = 1
+)
+
+// manifestEntry is one interior XML/TIF file's expected size/CRC32, read
+// straight from the ZIP central directory - no decompression needed to get
+// these, since zip.File.CRC32/UncompressedSize64 are stored there already.
+type manifestEntry struct {
+	Name  string `json:"name"`
+	CRC32 uint32 `json:"crc32"`
+	Size  uint64 `json:"size"`
+}
+
+// manifestPatent is one patentDir's expected ZIP member and its contents.
+type manifestPatent struct {
+	TarFile   string          `json:"tar_file"`
+	ZipMember string          `json:"zip_member"`
+	ZipSize   int64           `json:"zip_size"`
+	Entries   []manifestEntry `json:"entries"`
+}
+
+// manifest is a year's worth of expected archive state, persisted as
+// manifest-YYYY.json under cfg.ArchiveBase/.manifest/.
+type manifest struct {
+	Version     int                       `json:"version"`
+	Year        int                       `json:"year"`
+	GeneratedAt string                    `json:"generated_at"`
+	Patents     map[string]manifestPatent `json:"patents"` // keyed by patentDir
+}
+
+func manifestDir() string {
+	return filepath.Join(cfg.ArchiveBase, manifestDirName)
+}
+
+func manifestPath(year int) string {
+	return filepath.Join(manifestDir(), fmt.Sprintf("manifest-%d.json", year))
+}
+
+// loadManifest reads manifest-YYYY.json, returning ok=false (not an error)
+// when it doesn't exist yet - the caller treats that as "first run".
+func loadManifest(year int) (*manifest, bool, error) {
+	data, err := os.ReadFile(manifestPath(year))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, false, fmt.Errorf("parsing manifest: %w", err)
+	}
+	return &m, true, nil
+}
+
+// saveManifest writes m atomically (tmp file + rename), the same pattern
+// tarindex.go's sidecar writer uses.
+func saveManifest(m *manifest) error {
+	if err := os.MkdirAll(manifestDir(), 0755); err != nil {
+		return fmt.Errorf("creating manifest dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := manifestPath(m.Year)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("writing manifest tmp file: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// ----------------------------------------------------------------------------
+// Collecting current archive state
+// ----------------------------------------------------------------------------
+
+// collectYearState walks every TAR archive for year and returns the
+// patentDir -> manifestPatent state it currently finds. By default it reads
+// each ZIP's central directory only - the fast path the request calls for,
+// since zip.File.CRC32 is already trustworthy without decompressing the
+// entry. With deep=true it additionally decompresses every entry and
+// recomputes its CRC32, catching corruption that leaves the central
+// directory's recorded size/CRC32 untouched but the compressed payload
+// itself damaged.
+func collectYearState(year int, deep bool) (map[string]manifestPatent, error) {
+	yearDir := filepath.Join(cfg.ArchiveBase, strconv.Itoa(year))
+	tarPaths, err := listTarFiles(yearDir)
+	if err != nil {
+		return nil, fmt.Errorf("listing TAR files in %s: %w", yearDir, err)
+	}
+
+	state := make(map[string]manifestPatent)
+	for _, tarPath := range tarPaths {
+		if err := collectTarState(tarPath, state, deep); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", tarPath, err)
+		}
+	}
+	return state, nil
+}
+
+func collectTarState(tarPath string, state map[string]manifestPatent, deep bool) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tarFilename := filepath.Base(tarPath)
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading TAR entries: %w", err)
+		}
+		if !strings.HasSuffix(strings.ToUpper(header.Name), ".ZIP") {
+			continue
+		}
+
+		base := filepath.Base(header.Name)
+		patentDir := strings.TrimSuffix(base, filepath.Ext(base))
+
+		zipData, err := io.ReadAll(tr)
+		if err != nil {
+			state[patentDir] = manifestPatent{TarFile: tarFilename, ZipMember: header.Name, ZipSize: header.Size}
+			log.Printf("verify: %s: truncated while reading ZIP member: %v", header.Name, err)
+			continue
+		}
+
+		entries, err := zipCentralDirectoryEntries(zipData, deep)
+		if err != nil {
+			state[patentDir] = manifestPatent{TarFile: tarFilename, ZipMember: header.Name, ZipSize: int64(len(zipData))}
+			log.Printf("verify: %s: unreadable or corrupt ZIP: %v", header.Name, err)
+			continue
+		}
+
+		state[patentDir] = manifestPatent{
+			TarFile:   tarFilename,
+			ZipMember: header.Name,
+			ZipSize:   int64(len(zipData)),
+			Entries:   entries,
+		}
+	}
+	return nil
+}
+
+// zipCentralDirectoryEntries lists a ZIP's XML/TIF members' name/size/CRC32
+// straight from the central directory, without decompressing any of them -
+// unless deep is true, in which case each entry is also decompressed and its
+// actual CRC32 compared against the central directory's recorded value.
+func zipCentralDirectoryEntries(zipData []byte, deep bool) ([]manifestEntry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []manifestEntry
+	for _, file := range zr.File {
+		upper := strings.ToUpper(file.Name)
+		if !strings.HasSuffix(upper, ".XML") && !strings.HasSuffix(upper, ".TIF") {
+			continue
+		}
+
+		if deep {
+			actual, err := recomputeEntryCRC32(file)
+			if err != nil {
+				return nil, fmt.Errorf("decompressing %s: %w", file.Name, err)
+			}
+			if actual != file.CRC32 {
+				return nil, fmt.Errorf("%s: CRC32 mismatch after decompression (central directory says %08x, decompressed data is %08x)", file.Name, file.CRC32, actual)
+			}
+		}
+
+		entries = append(entries, manifestEntry{
+			Name:  file.Name,
+			CRC32: file.CRC32,
+			Size:  file.UncompressedSize64,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// recomputeEntryCRC32 decompresses one ZIP entry and recomputes its CRC32
+// from the actual bytes, for the "deep" verify pass - the central directory
+// can say size/CRC32 match while the file itself is still corrupt if the
+// compressed data (not the central directory record) is what rotted.
+func recomputeEntryCRC32(file *zip.File) (uint32, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, bufio.NewReader(rc)); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// ----------------------------------------------------------------------------
+// Diffing current state against a manifest
+// ----------------------------------------------------------------------------
+
+type verifyStatus string
+
+const (
+	statusOK         verifyStatus = "ok"
+	statusNew        verifyStatus = "new"
+	statusMissing    verifyStatus = "missing"
+	statusAltered    verifyStatus = "altered"
+	statusCorrupt    verifyStatus = "corrupt"
+	statusSuppressed verifyStatus = "suppressed"
+)
+
+type verifyDetail struct {
+	PatentDir string       `json:"patent_dir"`
+	Status    verifyStatus `json:"status"`
+	Message   string       `json:"message,omitempty"`
+}
+
+type verifySummary struct {
+	Year    int            `json:"year"`
+	OK      int            `json:"ok"`
+	Missing int            `json:"missing"`
+	Corrupt int            `json:"corrupt"`
+	Altered int            `json:"altered"`
+	New     int            `json:"new"`
+	Total   int            `json:"total"`
+	Details []verifyDetail `json:"details,omitempty"`
+}
+
+// loadWhitelist reads a file of patentDir glob patterns, one per line
+// (blank lines and lines starting with # are ignored).
+func loadWhitelist(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+func whitelisted(patterns []string, patentDir string) bool {
+	for _, pattern := range patterns {
+		if ok, err := filepath.Match(pattern, patentDir); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// diffManifest compares current archive state against the manifest,
+// returning one verifyDetail per patentDir that isn't a clean match. Deltas
+// for a patentDir matching a whitelist pattern are reported as "suppressed"
+// (and counted as ok, not as missing/corrupt/altered) rather than dropped
+// silently, so ops still sees that something changed.
+func diffManifest(m *manifest, current map[string]manifestPatent, whitelist []string) *verifySummary {
+	summary := &verifySummary{Year: m.Year}
+
+	for patentDir, expected := range m.Patents {
+		actual, stillPresent := current[patentDir]
+		status, msg := "", ""
+
+		switch {
+		case !stillPresent:
+			status, msg = string(statusMissing), fmt.Sprintf("expected in %s, not found in any archive", expected.TarFile)
+		case len(actual.Entries) == 0 && len(expected.Entries) > 0:
+			status, msg = string(statusCorrupt), "ZIP could not be read (see log for the underlying error)"
+		case !sameEntries(expected.Entries, actual.Entries) || actual.ZipSize != expected.ZipSize:
+			status, msg = string(statusAltered), describeEntryDiff(expected, actual)
+		default:
+			status = string(statusOK)
+		}
+
+		if status != string(statusOK) && whitelisted(whitelist, patentDir) {
+			summary.Details = append(summary.Details, verifyDetail{PatentDir: patentDir, Status: statusSuppressed, Message: msg})
+			status = string(statusOK)
+		} else if status != string(statusOK) {
+			summary.Details = append(summary.Details, verifyDetail{PatentDir: patentDir, Status: verifyStatus(status), Message: msg})
+		}
+
+		switch verifyStatus(status) {
+		case statusOK:
+			summary.OK++
+		case statusMissing:
+			summary.Missing++
+		case statusCorrupt:
+			summary.Corrupt++
+		case statusAltered:
+			summary.Altered++
+		}
+		summary.Total++
+	}
+
+	for patentDir := range current {
+		if _, inManifest := m.Patents[patentDir]; inManifest {
+			continue
+		}
+		summary.New++
+		summary.Total++
+		summary.Details = append(summary.Details, verifyDetail{PatentDir: patentDir, Status: statusNew, Message: "not present in manifest"})
+	}
+
+	sort.Slice(summary.Details, func(i, j int) bool { return summary.Details[i].PatentDir < summary.Details[j].PatentDir })
+	return summary
+}
+
+func sameEntries(a, b []manifestEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].CRC32 != b[i].CRC32 || a[i].Size != b[i].Size {
+			return false
+		}
+	}
+	return true
+}
+
+func describeEntryDiff(expected, actual manifestPatent) string {
+	if actual.ZipSize != expected.ZipSize {
+		return fmt.Sprintf("ZIP size changed: expected %d bytes, found %d", expected.ZipSize, actual.ZipSize)
+	}
+	return "one or more entry CRC32/size values changed since the manifest was built"
+}
+
+// ----------------------------------------------------------------------------
+// Orchestration
+// ----------------------------------------------------------------------------
+
+// runVerify builds a manifest for year on first run (returning an all-ok
+// summary), or diffs the current archive state against an existing manifest
+// on later runs. whitelistPath may be empty. deep additionally recomputes
+// every entry's CRC32 from its decompressed bytes instead of trusting the
+// ZIP central directory - see zipCentralDirectoryEntries.
+func runVerify(year int, whitelistPath string, deep bool) (*verifySummary, error) {
+	current, err := collectYearState(year, deep)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, found, err := loadManifest(year)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		m := &manifest{Version: manifestVersion, Year: year, GeneratedAt: time.Now().Format(time.RFC3339), Patents: current}
+		if err := saveManifest(m); err != nil {
+			return nil, fmt.Errorf("writing manifest: %w", err)
+		}
+		log.Printf("verify: no manifest for year %d yet, wrote one covering %d patents", year, len(current))
+		return &verifySummary{Year: year, OK: len(current), Total: len(current)}, nil
+	}
+
+	whitelist, err := loadWhitelist(whitelistPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading whitelist: %w", err)
+	}
+
+	return diffManifest(existing, current, whitelist), nil
+}
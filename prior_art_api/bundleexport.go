@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ============================================================================
+// Bulk patent export: GET /api/patent/{pub}/bundle and POST
+// /api/patents/bundle stream a ZIP archive built with archive/zip.NewWriter
+// wrapped directly around the http.ResponseWriter - no temp file, chunked
+// transfer. Per patent the bundle holds {pub}/patent.xml, {pub}/patent.json
+// and {pub}/figures/D00001.tif (or .png with ?images=png), plus a root
+// manifest.json naming every included file's size/CRC32 and, if any patent
+// failed, a root errors.json rather than aborting the whole download.
+// ============================================================================
+
+// bundleOptions controls what a bundle request includes.
+type bundleOptions struct {
+	images  string          // "raw" (default) or "png" - see negotiateFormat for the rest of the format story
+	include map[string]bool // xml, json, figures - all true when the caller omits ?include=
+}
+
+func parseBundleOptions(q url.Values) bundleOptions {
+	opts := bundleOptions{images: "raw", include: map[string]bool{"xml": true, "json": true, "figures": true}}
+
+	if images := q.Get("images"); images == "png" {
+		opts.images = "png"
+	}
+
+	if include := q.Get("include"); include != "" {
+		opts.include = map[string]bool{}
+		for _, part := range strings.Split(include, ",") {
+			part = strings.TrimSpace(part)
+			if part != "" {
+				opts.include[part] = true
+			}
+		}
+	}
+
+	return opts
+}
+
+// bundleManifestEntry is one file's record in the bundle's root manifest.json.
+type bundleManifestEntry struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	CRC32 uint32 `json:"crc32"`
+}
+
+// bundleErrorEntry records one patent that couldn't be added to the bundle,
+// so a partial failure doesn't abort the rest of the download.
+type bundleErrorEntry struct {
+	PubNumber string `json:"pub_number"`
+	Error     string `json:"error"`
+}
+
+// writeBundle streams pubNumbers into a ZIP archive on w, stopping further
+// patents (and recording them in errors.json instead) once cfg.MaxBundleBytes
+// of uncompressed content has been written. cfg.MaxBundleBytes <= 0 means
+// unbounded.
+func writeBundle(w io.Writer, pubNumbers []string, opts bundleOptions) error {
+	zw := zip.NewWriter(w)
+
+	var manifest []bundleManifestEntry
+	var bundleErrors []bundleErrorEntry
+	var totalBytes int64
+
+	for _, pub := range pubNumbers {
+		if cfg.MaxBundleBytes > 0 && totalBytes >= cfg.MaxBundleBytes {
+			bundleErrors = append(bundleErrors, bundleErrorEntry{PubNumber: pub, Error: "skipped: bundle size cap reached"})
+			continue
+		}
+
+		entries, n, err := addPatentToBundle(zw, pub, opts)
+		if err != nil {
+			log.Printf("bundle: %s: %v", pub, err)
+			bundleErrors = append(bundleErrors, bundleErrorEntry{PubNumber: pub, Error: err.Error()})
+			continue
+		}
+		manifest = append(manifest, entries...)
+		totalBytes += n
+	}
+
+	if err := writeBundleJSON(zw, "manifest.json", manifest); err != nil {
+		return err
+	}
+	if len(bundleErrors) > 0 {
+		if err := writeBundleJSON(zw, "errors.json", bundleErrors); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// addPatentToBundle adds one patent's files under {pub}/ to zw, returning
+// the manifest entries it wrote and the total uncompressed bytes added.
+func addPatentToBundle(zw *zip.Writer, pub string, opts bundleOptions) ([]bundleManifestEntry, int64, error) {
+	lookup, err := lookupPatent(pub)
+	if err != nil {
+		return nil, 0, fmt.Errorf("patent not found: %s", pub)
+	}
+
+	extracted, err := extractFromArchive(lookup)
+	if err != nil {
+		return nil, 0, fmt.Errorf("extraction failed: %w", err)
+	}
+
+	dir := normalizePubNumber(pub)
+	var entries []bundleManifestEntry
+	var total int64
+
+	if opts.include["xml"] {
+		entry, err := addZipEntry(zw, dir+"/patent.xml", extracted.XMLData)
+		if err != nil {
+			return nil, 0, fmt.Errorf("writing patent.xml: %w", err)
+		}
+		entries = append(entries, entry)
+		total += entry.Size
+	}
+
+	var doc *PatentDoc
+	if opts.include["json"] || opts.include["figures"] {
+		doc, err = parsePatentXML(extracted.XMLData, extracted, lookup)
+		if err != nil {
+			return nil, 0, fmt.Errorf("parsing patent XML: %w", err)
+		}
+	}
+
+	if opts.include["json"] {
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, 0, fmt.Errorf("marshaling patent.json: %w", err)
+		}
+		entry, err := addZipEntry(zw, dir+"/patent.json", data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("writing patent.json: %w", err)
+		}
+		entries = append(entries, entry)
+		total += entry.Size
+	}
+
+	if opts.include["figures"] {
+		for _, drawing := range doc.Drawings {
+			data, ext, err := bundleFigureBytes(lookup, drawing.Num, opts.images)
+			if err != nil {
+				log.Printf("bundle: %s figure %d: %v", pub, drawing.Num, err)
+				continue
+			}
+			name := fmt.Sprintf("%s/figures/D%05d.%s", dir, drawing.Num, ext)
+			entry, err := addZipEntry(zw, name, data)
+			if err != nil {
+				return nil, 0, fmt.Errorf("writing %s: %w", name, err)
+			}
+			entries = append(entries, entry)
+			total += entry.Size
+		}
+	}
+
+	return entries, total, nil
+}
+
+// bundleFigureBytes returns one figure's bytes and file extension per
+// opts.images: "raw" serves the TIF as stored, "png" transcodes it through
+// the same derived-image pipeline handleFigureImage uses (default settings -
+// page 0, no resize).
+func bundleFigureBytes(lookup *PatentLookup, figureNum int, images string) ([]byte, string, error) {
+	tifData, _, err := extractTIFFromArchive(lookup, figureNum)
+	if err != nil {
+		return nil, "", err
+	}
+	if images != "png" {
+		return tifData, "tif", nil
+	}
+
+	key := derivedImageKey{pubNumber: normalizePubNumber(lookup.PubNumber), figureNum: figureNum, format: "png", fit: "contain"}
+	entry, err := renderDerivedImage(key, tifData)
+	if err != nil {
+		return nil, "", fmt.Errorf("transcoding figure %d: %w", figureNum, err)
+	}
+	return entry.data, "png", nil
+}
+
+// addZipEntry writes data as a new, uncompressed-CRC-tracked ZIP entry
+// (zip.Writer computes its own CRC32 for the archive; this one is for the
+// manifest so a client can verify bundle contents without re-opening it).
+func addZipEntry(zw *zip.Writer, name string, data []byte) (bundleManifestEntry, error) {
+	fw, err := zw.Create(name)
+	if err != nil {
+		return bundleManifestEntry{}, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return bundleManifestEntry{}, err
+	}
+	return bundleManifestEntry{Name: name, Size: int64(len(data)), CRC32: crc32.ChecksumIEEE(data)}, nil
+}
+
+func writeBundleJSON(zw *zip.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// handleBundleGet serves GET /api/patent/{pub}/bundle - a single-patent ZIP
+// bundle, same layout and query params as the multi-patent POST endpoint.
+func handleBundleGet(w http.ResponseWriter, r *http.Request) {
+	pub := r.PathValue("pub")
+	writeBundleResponse(w, []string{pub}, "patent-"+normalizePubNumber(pub)+".zip", r.URL.Query())
+}
+
+// bundlePatentsRequest is the POST /api/patents/bundle request body.
+type bundlePatentsRequest struct {
+	PubNumbers []string `json:"pub_numbers"`
+}
+
+// handleBundlePost serves POST /api/patents/bundle - a multi-patent ZIP
+// bundle for the pub_numbers listed in the JSON body.
+func handleBundlePost(w http.ResponseWriter, r *http.Request) {
+	var req bundlePatentsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.PubNumbers) == 0 {
+		http.Error(w, "pub_numbers must not be empty", http.StatusBadRequest)
+		return
+	}
+	writeBundleResponse(w, req.PubNumbers, "patents-bundle.zip", r.URL.Query())
+}
+
+func writeBundleResponse(w http.ResponseWriter, pubNumbers []string, filename string, query url.Values) {
+	opts := parseBundleOptions(query)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if err := writeBundle(w, pubNumbers, opts); err != nil {
+		log.Printf("bundle: stream failed: %v", err)
+	}
+}
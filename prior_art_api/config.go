@@ -14,6 +14,18 @@ type Config struct {
 	DBPassword  string
 	ServerPort  int
 	ArchiveBase string
+	IndexPath   string
+
+	// ArchiveWhitelistPath is optional: when set, it names a file of
+	// patentDir glob patterns (one per line) whose archive-integrity deltas
+	// runVerify should suppress - e.g. patents known to have been
+	// re-mastered on disk. Empty means "no whitelist".
+	ArchiveWhitelistPath string
+
+	// MaxBundleBytes caps the total uncompressed size writeBundle will add
+	// to a single ZIP export before it starts skipping (and recording in
+	// errors.json) the remaining requested patents. <= 0 means unbounded.
+	MaxBundleBytes int64
 }
 
 // Default configuration - can be overridden via environment variables
@@ -25,6 +37,13 @@ var cfg = Config{
 	DBPassword:  getEnv("DB_PASSWORD", "mark123"),
 	ServerPort:  getEnvInt("SERVER_PORT", 8096),
 	ArchiveBase: getEnv("ARCHIVE_BASE", "/mnt/patents/data/historical"),
+	// IndexPath is optional: when set, extractFromArchive looks the patent's
+	// XML up in a patents.idx built by the diagnostic analyzer's BuildIndex
+	// before falling back to the TAR/ZIP walk. Empty means "no index" - the
+	// walk is always correct, the index is purely an accelerator.
+	IndexPath:            getEnv("PATENT_INDEX_PATH", ""),
+	ArchiveWhitelistPath: getEnv("ARCHIVE_WHITELIST_PATH", ""),
+	MaxBundleBytes:       getEnvInt64("MAX_BUNDLE_BYTES", 500*1024*1024),
 }
 
 // getEnv returns environment variable value or default
@@ -44,3 +63,13 @@ func getEnvInt(key string, def int) int {
 	}
 	return def
 }
+
+// getEnvInt64 returns environment variable as int64 or default
+func getEnvInt64(key string, def int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return def
+}
@@ -67,6 +67,39 @@ func lookupPatent(pubNumber string) (*PatentLookup, error) {
 	return &patent, nil
 }
 
+// listAllPatents returns every patent_data_unified row, for the "match"
+// batch CLI's title-slug grouping pass (findMatchClusters).
+func listAllPatents() ([]PatentLookup, error) {
+	rows, err := db.Query(`SELECT pub_number, pub_date, raw_xml_path, year, title FROM patent_data_unified`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query patents: %w", err)
+	}
+	defer rows.Close()
+
+	var patents []PatentLookup
+	for rows.Next() {
+		var p PatentLookup
+		var pubDate sql.NullTime
+		var rawPath sql.NullString
+		var title sql.NullString
+
+		if err := rows.Scan(&p.PubNumber, &pubDate, &rawPath, &p.Year, &title); err != nil {
+			return nil, fmt.Errorf("failed to scan patent row: %w", err)
+		}
+		if pubDate.Valid {
+			p.PubDate = &pubDate.Time
+		}
+		if rawPath.Valid {
+			p.RawXMLPath = rawPath.String
+		}
+		if title.Valid {
+			p.Title = title.String
+		}
+		patents = append(patents, p)
+	}
+	return patents, rows.Err()
+}
+
 // saveFigureDescription saves a figure description with auto-incrementing version
 func saveFigureDescription(pubNumber string, fig FigureDescriptionInput) (int, error) {
 	var version int
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Description parsing: parseDescriptionContent replaces a regex-plus-
+// bubble-sort reconstruction with a streaming encoding/xml.Decoder walk over
+// an element stack. Headings and paragraphs are emitted in the order the
+// decoder sees them - no position bookkeeping or sort needed, so a <heading>
+// nested inside a <section> lands in the right place for free - and inline
+// markup (<sub>, <sup>, <i>, <b>) and cross-references (<figref>,
+// <claim-ref>) are captured as Style/Refs annotations instead of being
+// stripped to plain text. Text itself stays a flattened plain string for
+// backwards compatibility.
+//
+// Pre-2005 USPTO bodies use <PDAT> text runs instead of heading/p elements;
+// parseDescriptionPDAT below produces the same []DescPara so buildDescription
+// never has to branch on format downstream.
+// ============================================================================
+
+// parseDescriptionContent parses a <description> element's inner XML into
+// document-ordered DescPara records, dispatching on which body format it is.
+func parseDescriptionContent(content string) []DescPara {
+	if strings.Contains(content, "<PDAT>") {
+		return parseDescriptionPDAT(content)
+	}
+	return parseDescriptionXML(content)
+}
+
+// descBuilder accumulates one heading/paragraph/list-item's text plus the
+// Refs and Style runs found inside it, recording offsets against the raw
+// (not-yet-trimmed) text so they can be shifted once in build().
+type descBuilder struct {
+	kind       string
+	num        int
+	text       strings.Builder
+	refs       []Ref
+	openRefs   []int
+	styles     []StyleRun
+	openStyles []int
+}
+
+func newDescBuilder(kind string) *descBuilder {
+	return &descBuilder{kind: kind}
+}
+
+func (b *descBuilder) pushRef(refType, target string) {
+	b.refs = append(b.refs, Ref{Type: refType, Target: target, Offset: b.text.Len()})
+	b.openRefs = append(b.openRefs, len(b.refs)-1)
+}
+
+func (b *descBuilder) popRef() {
+	if len(b.openRefs) == 0 {
+		return
+	}
+	b.openRefs = b.openRefs[:len(b.openRefs)-1]
+}
+
+func (b *descBuilder) pushStyle(style string) {
+	b.styles = append(b.styles, StyleRun{Style: style, Start: b.text.Len()})
+	b.openStyles = append(b.openStyles, len(b.styles)-1)
+}
+
+func (b *descBuilder) popStyle() {
+	if len(b.openStyles) == 0 {
+		return
+	}
+	idx := b.openStyles[len(b.openStyles)-1]
+	b.openStyles = b.openStyles[:len(b.openStyles)-1]
+	b.styles[idx].End = b.text.Len()
+}
+
+// build flattens the accumulated text, trimming surrounding whitespace and
+// shifting every recorded offset by however much was trimmed off the front.
+func (b *descBuilder) build() DescPara {
+	raw := b.text.String()
+	trimmed := strings.TrimLeft(raw, " \t\n\r")
+	leadingTrim := len(raw) - len(trimmed)
+	trimmed = strings.TrimRight(trimmed, " \t\n\r")
+
+	para := DescPara{Type: b.kind, Num: b.num, Text: trimmed}
+	for _, r := range b.refs {
+		r.Offset = clampOffset(r.Offset-leadingTrim, len(trimmed))
+		para.Refs = append(para.Refs, r)
+	}
+	for _, s := range b.styles {
+		s.Start = clampOffset(s.Start-leadingTrim, len(trimmed))
+		s.End = clampOffset(s.End-leadingTrim, len(trimmed))
+		if s.End > s.Start {
+			para.Style = append(para.Style, s)
+		}
+	}
+	return para
+}
+
+func clampOffset(n, max int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// attrValue returns a StartElement's attribute value by local name, or "" if
+// it isn't present.
+func attrValue(t xml.StartElement, name string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+var styleElements = map[string]string{
+	"i":   "italic",
+	"b":   "bold",
+	"sub": "sub",
+	"sup": "sup",
+}
+
+// parseDescriptionXML streams a modern (2005+) <description> body - nested
+// <section>/<heading>/<p>/<ul>/<ol>/<li> elements with inline <i>, <b>,
+// <sub>, <sup>, <figref>, <claim-ref>, and <chemistry> markup - into
+// document-ordered DescPara records.
+func parseDescriptionXML(content string) []DescPara {
+	dec := xml.NewDecoder(strings.NewReader(content))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var result []DescPara
+	var cur *descBuilder
+
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if para := cur.build(); para.Text != "" {
+			result = append(result, para)
+		}
+		cur = nil
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Malformed tail (e.g. a stray unescaped "&") - keep whatever
+			// was already parsed instead of discarding the whole document.
+			break
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "heading":
+				flush()
+				cur = newDescBuilder("heading")
+			case "p":
+				flush()
+				cur = newDescBuilder("paragraph")
+				cur.num, _ = strconv.Atoi(attrValue(t, "num"))
+			case "li":
+				flush()
+				cur = newDescBuilder("list-item")
+			case "figref":
+				if cur != nil {
+					cur.pushRef("figure", attrValue(t, "idref"))
+				}
+			case "claim-ref":
+				if cur != nil {
+					cur.pushRef("claim", attrValue(t, "idref"))
+				}
+			case "i", "b", "sub", "sup":
+				if cur != nil {
+					cur.pushStyle(styleElements[t.Name.Local])
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "heading", "p", "li":
+				flush()
+			case "figref", "claim-ref":
+				if cur != nil {
+					cur.popRef()
+				}
+			case "i", "b", "sub", "sup":
+				if cur != nil {
+					cur.popStyle()
+				}
+			}
+		case xml.CharData:
+			if cur != nil {
+				cur.text.Write(t)
+			}
+		}
+	}
+	flush()
+	return result
+}
+
+// parseDescriptionPDAT parses the pre-2005 <PDAT>-run USPTO body format -
+// paragraphs as <PARA ID="P-00001"><PDAT>...</PDAT></PARA> and headings as
+// <H LVL="1"><PDAT>...</PDAT></H> - into the same []DescPara shape
+// parseDescriptionXML produces.
+func parseDescriptionPDAT(content string) []DescPara {
+	dec := xml.NewDecoder(strings.NewReader(content))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var result []DescPara
+	var cur *descBuilder
+
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if para := cur.build(); para.Text != "" {
+			result = append(result, para)
+		}
+		cur = nil
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			break
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "PARA":
+				flush()
+				cur = newDescBuilder("paragraph")
+				cur.num = pdatParaNum(attrValue(t, "ID"))
+			case "H":
+				flush()
+				cur = newDescBuilder("heading")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "PARA", "H":
+				flush()
+			}
+		case xml.CharData:
+			if cur != nil {
+				cur.text.Write(t)
+			}
+		}
+	}
+	flush()
+	return result
+}
+
+// pdatParaNum extracts the trailing digits of a pre-2005 paragraph ID like
+// "P-00001" (-> 1), returning 0 if id doesn't end in a digit run.
+func pdatParaNum(id string) int {
+	i := len(id)
+	for i > 0 && id[i-1] >= '0' && id[i-1] <= '9' {
+		i--
+	}
+	n, _ := strconv.Atoi(id[i:])
+	return n
+}
@@ -0,0 +1,119 @@
+package main
+
+import "testing"
+
+func TestParseDescriptionXML_NestedHeadingInSection(t *testing.T) {
+	content := `<section>
+<heading>Background</heading>
+<p num="0001">First paragraph.</p>
+</section>
+<p num="0002">Second paragraph.</p>`
+
+	paras := parseDescriptionXML(content)
+	if len(paras) != 3 {
+		t.Fatalf("expected 3 paras, got %d: %+v", len(paras), paras)
+	}
+	if paras[0].Type != "heading" || paras[0].Text != "Background" {
+		t.Errorf("expected heading first, got %+v", paras[0])
+	}
+	if paras[1].Num != 1 || paras[1].Text != "First paragraph." {
+		t.Errorf("unexpected second para: %+v", paras[1])
+	}
+	if paras[2].Num != 2 || paras[2].Text != "Second paragraph." {
+		t.Errorf("unexpected third para: %+v", paras[2])
+	}
+}
+
+func TestParseDescriptionXML_MissingNumNotDropped(t *testing.T) {
+	content := `<p>No num attribute here.</p>`
+
+	paras := parseDescriptionXML(content)
+	if len(paras) != 1 {
+		t.Fatalf("expected 1 para, got %d", len(paras))
+	}
+	if paras[0].Num != 0 || paras[0].Text != "No num attribute here." {
+		t.Errorf("unexpected para: %+v", paras[0])
+	}
+}
+
+func TestParseDescriptionXML_ProcessingInstructionMidBody(t *testing.T) {
+	content := `<p num="0001">Before.</p><?xml-stylesheet type="text/xsl" href="patent.xsl"?><p num="0002">After.</p>`
+
+	paras := parseDescriptionXML(content)
+	if len(paras) != 2 {
+		t.Fatalf("expected 2 paras, got %d: %+v", len(paras), paras)
+	}
+	if paras[0].Text != "Before." || paras[1].Text != "After." {
+		t.Errorf("processing instruction disrupted parsing: %+v", paras)
+	}
+}
+
+func TestParseDescriptionXML_ChemistryBlock(t *testing.T) {
+	content := `<p num="0001">Formula <chemistry id="CHEM-1">C<sub>6</sub>H<sub>6</sub></chemistry> is benzene.</p>`
+
+	paras := parseDescriptionXML(content)
+	if len(paras) != 1 {
+		t.Fatalf("expected 1 para, got %d", len(paras))
+	}
+	if paras[0].Text != "Formula C6H6 is benzene." {
+		t.Errorf("chemistry block mangled: %q", paras[0].Text)
+	}
+	if len(paras[0].Style) != 2 {
+		t.Fatalf("expected 2 sub style runs, got %d: %+v", len(paras[0].Style), paras[0].Style)
+	}
+	for _, s := range paras[0].Style {
+		if s.Style != "sub" {
+			t.Errorf("expected sub style, got %q", s.Style)
+		}
+	}
+}
+
+func TestParseDescriptionXML_FigrefAndClaimRef(t *testing.T) {
+	content := `<p num="0001">See <figref idref="DRAWINGS">FIG. 1</figref> and <claim-ref idref="CLM-00001">claim 1</claim-ref>.</p>`
+
+	paras := parseDescriptionXML(content)
+	if len(paras) != 1 {
+		t.Fatalf("expected 1 para, got %d", len(paras))
+	}
+	if len(paras[0].Refs) != 2 {
+		t.Fatalf("expected 2 refs, got %d: %+v", len(paras[0].Refs), paras[0].Refs)
+	}
+	if paras[0].Refs[0].Type != "figure" || paras[0].Refs[0].Target != "DRAWINGS" {
+		t.Errorf("unexpected figure ref: %+v", paras[0].Refs[0])
+	}
+	if paras[0].Refs[1].Type != "claim" || paras[0].Refs[1].Target != "CLM-00001" {
+		t.Errorf("unexpected claim ref: %+v", paras[0].Refs[1])
+	}
+}
+
+func TestParseDescriptionPDAT(t *testing.T) {
+	content := `<PARA ID="P-00001"><PDAT>First para.</PDAT></PARA>` +
+		`<H LVL="1"><PDAT>A Heading</PDAT></H>` +
+		`<PARA ID="P-00002"><PDAT>Second para.</PDAT></PARA>`
+
+	paras := parseDescriptionPDAT(content)
+	if len(paras) != 3 {
+		t.Fatalf("expected 3 paras, got %d: %+v", len(paras), paras)
+	}
+	if paras[0].Num != 1 || paras[0].Text != "First para." {
+		t.Errorf("unexpected first para: %+v", paras[0])
+	}
+	if paras[1].Type != "heading" || paras[1].Text != "A Heading" {
+		t.Errorf("unexpected heading: %+v", paras[1])
+	}
+	if paras[2].Num != 2 || paras[2].Text != "Second para." {
+		t.Errorf("unexpected third para: %+v", paras[2])
+	}
+}
+
+func TestParseDescriptionContent_DispatchesOnFormat(t *testing.T) {
+	modern := parseDescriptionContent(`<p num="0001">Modern body.</p>`)
+	if len(modern) != 1 || modern[0].Text != "Modern body." {
+		t.Errorf("unexpected modern dispatch: %+v", modern)
+	}
+
+	legacy := parseDescriptionContent(`<PARA ID="P-00001"><PDAT>Legacy body.</PDAT></PARA>`)
+	if len(legacy) != 1 || legacy[0].Text != "Legacy body." {
+		t.Errorf("unexpected legacy dispatch: %+v", legacy)
+	}
+}
@@ -58,7 +58,8 @@ func zipCacheCleanup() {
 	}
 }
 
-// getCachedZIP returns ZIP data from cache or extracts from TAR and caches it
+// getCachedZIP returns ZIP data from cache, the TAR index (one ReadAt), or -
+// failing both - a full sequential TAR scan.
 func getCachedZIP(tarPath, patentDir string) ([]byte, string, error) {
 	key := tarPath + ":" + patentDir
 
@@ -76,11 +77,13 @@ func getCachedZIP(tarPath, patentDir string) ([]byte, string, error) {
 		return entry.data, entry.memberName, nil
 	}
 
-	// Cache miss - extract from TAR
-	log.Printf("Cache miss: %s — extracting from TAR", patentDir)
-	data, memberName, err := extractZIPFromTAR(tarPath, patentDir)
+	data, memberName, err := getZIPViaIndex(tarPath, patentDir)
 	if err != nil {
-		return nil, "", err
+		log.Printf("Cache miss: %s — extracting from TAR (%v)", patentDir, err)
+		data, memberName, err = extractZIPFromTAR(tarPath, patentDir)
+		if err != nil {
+			return nil, "", err
+		}
 	}
 
 	// Store in cache
@@ -95,6 +98,28 @@ func getCachedZIP(tarPath, patentDir string) ([]byte, string, error) {
 	return data, memberName, nil
 }
 
+// getZIPViaIndex serves a ZIP member straight out of tarPath's persisted
+// offset index when one is available and has an entry for patentDir. The
+// returned error is purely informational for getCachedZIP's fallback log
+// line - every failure here (no index, stale index, patentDir not indexed)
+// just means "use the sequential walk instead".
+func getZIPViaIndex(tarPath, patentDir string) ([]byte, string, error) {
+	idx, err := ensureTarIndex(tarPath)
+	if err != nil {
+		return nil, "", err
+	}
+	rec, ok := idx.lookup(patentDir)
+	if !ok {
+		return nil, "", fmt.Errorf("%s not in TAR index", patentDir)
+	}
+	data, err := readZIPAt(tarPath, rec)
+	if err != nil {
+		return nil, "", err
+	}
+	log.Printf("Index hit: %s (offset %d, %d bytes)", patentDir, rec.dataOffset, rec.size)
+	return data, rec.memberName, nil
+}
+
 // ============================================================================
 // Archive Extraction
 // ============================================================================
@@ -121,8 +146,40 @@ func parseArchivePath(lookup *PatentLookup) (tarPath, patentDir string, err erro
 	return tarPath, patentDir, nil
 }
 
-// extractFromArchive extracts patent files from TAR/ZIP archive
+// patentETag returns an ETag derived from the TAR index's CRC32 for
+// lookup's ZIP member, and ok=false whenever no index is loaded yet for that
+// TAR or it doesn't cover this patentDir - callers should treat that as "no
+// ETag available" rather than building the index just to answer one header.
+func patentETag(lookup *PatentLookup) (string, bool) {
+	tarPath, patentDir, err := parseArchivePath(lookup)
+	if err != nil {
+		return "", false
+	}
+
+	tarIndexCacheMu.Lock()
+	idx, ok := tarIndexCache[tarPath]
+	tarIndexCacheMu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	rec, ok := idx.lookup(patentDir)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(`"%s-%08x"`, patentDir, rec.crc32), true
+}
+
+// extractFromArchive extracts patent files from TAR/ZIP archive. When
+// cfg.IndexPath points at a patents.idx covering this patent, the XML is
+// read straight out of it instead - figures still require the full walk,
+// since the index only tracks the XML entry's location, not every TIFF.
 func extractFromArchive(lookup *PatentLookup) (*ExtractedFiles, error) {
+	if xmlData, ok := lookupXMLFromIndex(lookup.PubNumber); ok {
+		log.Printf("Index hit: %s", lookup.PubNumber)
+		return &ExtractedFiles{XMLData: xmlData, XMLPath: lookup.RawXMLPath}, nil
+	}
+
 	tarPath, patentDir, err := parseArchivePath(lookup)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ============================================================================
+// Fuzzy publication-number resolution: an in-memory trigram index over every
+// known pub_number (and, for /api/patent/search, title) so a lookup miss can
+// suggest ranked candidates instead of a bare 404 - a typo'd digit, a stray
+// "/" from copy-pasting "US2016/148332", or a bare 7-digit serial number
+// missing its year prefix should all still find the patent the user meant.
+// ============================================================================
+
+// PubSuggestion is one ranked candidate fuzzyLookup or searchPatents returns.
+type PubSuggestion struct {
+	PubNumber string  `json:"pub_number"`
+	Title     string  `json:"title"`
+	Score     float64 `json:"score"`
+}
+
+// fuzzyLookupLimit/fuzzyLookupThreshold bound fuzzyLookup's results - top 5
+// candidates scoring at least 0.6. searchPatentsLimit is looser, since
+// /api/patent/search is an explicit search rather than a "did you mean".
+const (
+	fuzzyLookupLimit     = 5
+	fuzzyLookupThreshold = 0.6
+	searchPatentsLimit   = 20
+)
+
+// pubIndexEntry is one lookup-DB row with its trigram sets precomputed, so
+// scoring a query against the whole index doesn't re-tokenize every
+// candidate's pub number and title on every call.
+type pubIndexEntry struct {
+	PubNumber string
+	Title     string
+	digitTri  map[string]bool
+	titleTri  map[string]bool
+}
+
+var pubIndexCache struct {
+	mu      sync.Mutex
+	entries []pubIndexEntry
+	loaded  bool
+}
+
+// loadPubIndex lazily builds and caches the in-memory trigram index from
+// every patent_data_unified row. Only the first caller after startup pays
+// the DB scan + trigram cost; everyone after that reuses the cached slice.
+func loadPubIndex() ([]pubIndexEntry, error) {
+	pubIndexCache.mu.Lock()
+	defer pubIndexCache.mu.Unlock()
+
+	if pubIndexCache.loaded {
+		return pubIndexCache.entries, nil
+	}
+
+	lookups, err := listAllPatents()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]pubIndexEntry, 0, len(lookups))
+	for _, l := range lookups {
+		entries = append(entries, pubIndexEntry{
+			PubNumber: l.PubNumber,
+			Title:     l.Title,
+			digitTri:  trigramSet(digitsOnly(l.PubNumber)),
+			titleTri:  trigramSet(titleSlug(l.Title)),
+		})
+	}
+
+	pubIndexCache.entries = entries
+	pubIndexCache.loaded = true
+	return entries, nil
+}
+
+// trigramSet returns the set of overlapping 3-character substrings of s. A
+// string shorter than 3 characters produces a single trigram of the whole
+// string, so a short query still compares as overlap instead of an empty set.
+func trigramSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	if len(s) < 3 {
+		if s != "" {
+			set[s] = true
+		}
+		return set
+	}
+	for i := 0; i+3 <= len(s); i++ {
+		set[s[i:i+3]] = true
+	}
+	return set
+}
+
+// digitsOnly strips everything but '0'-'9', so a copy-pasted "US2016/148332"
+// or "2016-148332" still compares cleanly against a clean pub number.
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isAmbiguousPubNumber reports whether normalized looks like a bare
+// application-serial core (7 digits, USPTO's normal serial length) with no
+// year prefix - an input short enough that it can't resolve to one exact
+// pub_number by itself.
+func isAmbiguousPubNumber(normalized string) bool {
+	return len(digitsOnly(normalized)) == 7
+}
+
+// levenshtein is the classic dynamic-programming edit distance, used to
+// catch a single transposed/substituted/inserted/deleted character.
+func levenshtein(a, b string) int {
+	if a == b {
+		return 0
+	}
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if b < a {
+		return b
+	}
+	return a
+}
+
+// scorePubNumber weights three signals into one score: trigram Jaccard over
+// the digits (queryDigitTri, precomputed by the caller), a flat +0.2 bonus
+// when query and the candidate's pub number are within Levenshtein distance
+// 2 of each other (catches a single edit precisely), and a +0.1 bonus when
+// the leading 4 digits (the publication year) agree.
+func scorePubNumber(queryDigitTri map[string]bool, query string, e pubIndexEntry) float64 {
+	score := jaccard(queryDigitTri, e.digitTri)
+
+	if levenshtein(query, e.PubNumber) <= 2 {
+		score += 0.2
+	}
+	if len(query) >= 4 && len(e.PubNumber) >= 4 && query[:4] == e.PubNumber[:4] {
+		score += 0.1
+	}
+
+	return score
+}
+
+// fuzzyLookup ranks every known publication against normalized and returns
+// the top fuzzyLookupLimit candidates scoring at least fuzzyLookupThreshold,
+// highest first.
+func fuzzyLookup(normalized string) ([]PubSuggestion, error) {
+	entries, err := loadPubIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	queryDigitTri := trigramSet(digitsOnly(normalized))
+
+	var candidates []PubSuggestion
+	for _, e := range entries {
+		if score := scorePubNumber(queryDigitTri, normalized, e); score >= fuzzyLookupThreshold {
+			candidates = append(candidates, PubSuggestion{PubNumber: e.PubNumber, Title: e.Title, Score: score})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	if len(candidates) > fuzzyLookupLimit {
+		candidates = candidates[:fuzzyLookupLimit]
+	}
+	return candidates, nil
+}
+
+// searchPatents resolves query against both publication numbers and titles,
+// using the same trigram-Jaccard approach fuzzyLookup uses for pub numbers,
+// for editorial users who only remember part of a title or have a mistyped
+// pub number. Each candidate's score is the better of its pub-number score
+// and its title score.
+func searchPatents(query string) ([]PubSuggestion, error) {
+	entries, err := loadPubIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	normalizedQuery := normalizePubNumber(query)
+	queryDigitTri := trigramSet(digitsOnly(normalizedQuery))
+	queryTitleTri := trigramSet(titleSlug(query))
+
+	var candidates []PubSuggestion
+	for _, e := range entries {
+		score := scorePubNumber(queryDigitTri, normalizedQuery, e)
+		if titleScore := jaccard(queryTitleTri, e.titleTri); titleScore > score {
+			score = titleScore
+		}
+		if score >= fuzzyLookupThreshold {
+			candidates = append(candidates, PubSuggestion{PubNumber: e.PubNumber, Title: e.Title, Score: score})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	if len(candidates) > searchPatentsLimit {
+		candidates = candidates[:searchPatentsLimit]
+	}
+	return candidates, nil
+}
@@ -1,16 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"image/png"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
-
-	"golang.org/x/image/tiff"
 )
 
 // handleRoot serves the API info page
@@ -21,12 +17,19 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 		"service": "Prior Art API",
 		"version": "2.0.0",
 		"endpoints": map[string]string{
-			"GET /api/patent/{pub}":                        "Get full patent document as JSON",
-			"GET /api/patent/{pub}/figures/{num}/image":    "Get figure image as PNG (or ?format=tif)",
-			"POST /api/patent/{pub}/figures/descriptions":  "Save figure descriptions (from fileApi)",
-			"GET /api/patent/{pub}/figures/descriptions":   "Get latest figure descriptions",
+			"GET /api/patent/{pub}":                            "Get full patent document as JSON (a lookup miss returns ranked suggestions; ?fuzzy=1 skips the exact lookup for an ambiguous input)",
+			"GET /api/patent/search":                           "Fuzzy search by pub number or title (?q=), returns ranked {pub_number,title,score} suggestions",
+			"GET /api/patent/{pub}/figures/{num}/image":        "Get figure image as png/jpeg (?format=, ?w=&h=&fit=, ?page=, or ?format=tif for raw)",
+			"GET /api/patent/{pub}/figures/{num}/meta":         "Get figure TIFF metadata (dimensions, DPI, compression, page count)",
+			"POST /api/patent/{pub}/figures/descriptions":      "Save figure descriptions (from fileApi)",
+			"GET /api/patent/{pub}/figures/descriptions":       "Get latest figure descriptions",
 			"GET /api/patent/{pub}/figures/{num}/descriptions": "Get all versions of a figure description",
-			"GET /health": "Health check",
+			"GET /api/patent/{pub}/bundle":                     "Download a ZIP bundle of one patent (?images=raw|png, ?include=xml,json,figures)",
+			"POST /api/patents/bundle":                         "Download a ZIP bundle of several patents (JSON body {pub_numbers: [...]})",
+			"POST /api/patent/compare":                         "Compare two publications (JSON body {pub_a, pub_b}), returns {status, reason, evidence}",
+			"GET /api/search":                                  "Full-text search (?q=, ?facet=cpc,year, ?limit=, ?year=)",
+			"GET /health/archives":                             "Archive integrity summary for a year (?year=)",
+			"GET /health":                                      "Health check",
 		},
 		"examples": []string{
 			"/api/patent/US20160148332A1",
@@ -58,16 +61,40 @@ func handleGetPatent(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Request for patent: %s", pubNumber)
 
+	normalized := normalizePubNumber(pubNumber)
+
+	// ?fuzzy=1 short-circuits an input that's too ambiguous to resolve to one
+	// exact pub_number (e.g. a bare 7-digit serial with no year prefix) -
+	// skip the exact lookup we already know will miss and go straight to
+	// suggestions.
+	if r.URL.Query().Get("fuzzy") == "1" && isAmbiguousPubNumber(normalized) {
+		sendSuggestions(w, normalized)
+		return
+	}
+
 	// Step 1: Lookup in database
 	lookup, err := lookupPatent(pubNumber)
 	if err != nil {
 		log.Printf("Lookup error: %v", err)
-		sendError(w, fmt.Sprintf("Patent not found: %s", pubNumber))
+		sendSuggestions(w, normalized)
 		return
 	}
 
 	log.Printf("Found: %s (year: %d)", lookup.Title, lookup.Year)
 
+	// If the TAR index already has this patent's ZIP CRC, use it as an ETag
+	// so a client that already has this exact extraction can skip the
+	// extract+parse round trip entirely. Best-effort: any miss (no index,
+	// patentDir not indexed, request not pointing at a local TAR) just falls
+	// through to the normal response.
+	if etag, ok := patentETag(lookup); ok {
+		w.Header().Set("ETag", etag)
+		if match := r.Header.Get("If-None-Match"); match == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
 	// Step 2: Extract from archive
 	extracted, err := extractFromArchive(lookup)
 	if err != nil {
@@ -101,7 +128,95 @@ func sendError(w http.ResponseWriter, message string) {
 	})
 }
 
-// handleFigureImage serves a patent figure as PNG (or raw TIF with ?format=tif)
+// sendSuggestions responds to a pub-number lookup miss with ranked
+// candidates from fuzzyLookup instead of a bare "not found" - still
+// HTTP 200, since {success:false, suggestions:[...]} is itself a usable
+// answer for a client to present as "did you mean?".
+func sendSuggestions(w http.ResponseWriter, normalized string) {
+	suggestions, err := fuzzyLookup(normalized)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Patent not found, and fuzzy lookup failed: %v", err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     false,
+		"suggestions": suggestions,
+	})
+}
+
+// handlePatentSearch serves GET /api/patent/search?q=: resolves q against
+// both publication numbers and titles via searchPatents, for editorial users
+// who only remember part of a title or have a mistyped pub number.
+func handlePatentSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		sendError(w, "Missing q parameter")
+		return
+	}
+
+	suggestions, err := searchPatents(query)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Search failed: %v", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"suggestions": suggestions,
+	})
+}
+
+// compareRequest is handleComparePatents' JSON body: two publication numbers
+// in any format normalizePubNumber accepts.
+type compareRequest struct {
+	PubA string `json:"pub_a"`
+	PubB string `json:"pub_b"`
+}
+
+// handleComparePatents serves POST /api/patent/compare: loads both
+// publications' full PatentDocs and runs ComparePatents' rule cascade,
+// returning its verdict as {status, reason, evidence}.
+func handleComparePatents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req compareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if req.PubA == "" || req.PubB == "" {
+		sendError(w, "pub_a and pub_b are required")
+		return
+	}
+
+	docA, err := loadPatentDoc(req.PubA)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to load %s: %v", req.PubA, err))
+		return
+	}
+	docB, err := loadPatentDoc(req.PubB)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to load %s: %v", req.PubB, err))
+		return
+	}
+
+	result := ComparePatents(docA, docB)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"status":   result.Status,
+		"reason":   result.Reason,
+		"evidence": result.Evidence,
+	})
+}
+
+// handleFigureImage serves a patent figure as PNG/JPEG (or raw TIF with
+// ?format=tif), with optional ?w=&h=&fit=contain|cover resizing and a
+// ?page=N to pick a page out of a multi-page TIFF. Format is ?format= if
+// given, else Accept-negotiated via negotiateFormat, defaulting to png.
 func handleFigureImage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -132,17 +247,36 @@ func handleFigureImage(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Extracted TIF: %s (%d bytes)", tifFilename, len(tifData))
 
+	q := r.URL.Query()
+
 	// Check if raw TIF was requested
-	if r.URL.Query().Get("format") == "tif" {
+	if q.Get("format") == "tif" {
 		w.Header().Set("Content-Type", "image/tiff")
 		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", tifFilename))
 		w.Write(tifData)
 		return
 	}
 
-	// Convert TIF to PNG
-	img, err := tiff.Decode(bytes.NewReader(tifData))
+	format := negotiateFormat(q.Get("format"), r.Header.Get("Accept"))
+	key := derivedImageKey{
+		pubNumber: normalizePubNumber(pubNumber),
+		figureNum: figureNum,
+		page:      parseIntParam(q.Get("page"), 0),
+		format:    format,
+		w:         parseIntParam(q.Get("w"), 0),
+		h:         parseIntParam(q.Get("h"), 0),
+		fit:       q.Get("fit"),
+	}
+	if key.fit == "" {
+		key.fit = "contain"
+	}
+
+	entry, err := renderDerivedImage(key, tifData)
 	if err != nil {
+		if format == "webp" {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
 		// Fallback: serve raw TIF if conversion fails
 		log.Printf("TIF decode failed, serving raw: %v", err)
 		w.Header().Set("Content-Type", "image/tiff")
@@ -151,11 +285,115 @@ func handleFigureImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	if err := png.Encode(w, img); err != nil {
-		log.Printf("PNG encode error: %v", err)
-		http.Error(w, "Failed to encode PNG", http.StatusInternalServerError)
+	w.Header().Set("Content-Type", entry.contentType)
+	w.Write(entry.data)
+}
+
+// handleSearch serves GET /api/search?q=...&facet=cpc,year&limit=...&year=...
+// Query syntax is documented on parseSearchQuery: bare terms AND together,
+// "OR"/"NOT" change the next term's role, "phrases" must match verbatim,
+// trailing* is a prefix match, and title:/claim:/inventor:/cpc: scope a term
+// to one field. year= (repeatable or comma-separated) restricts which
+// shards are searched; omitted means every shard under cfg.ArchiveBase/.index/.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		json.NewEncoder(w).Encode(searchResponse{Success: false, Error: "Missing q parameter"})
+		return
+	}
+
+	var years []int
+	for _, part := range strings.Split(strings.Join(q["year"], ","), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if y, err := strconv.Atoi(part); err == nil {
+			years = append(years, y)
+		}
+	}
+
+	var facets []string
+	for _, part := range strings.Split(q.Get("facet"), ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			facets = append(facets, part)
+		}
+	}
+
+	limit := parseIntParam(q.Get("limit"), defaultSearchLimit)
+
+	resp, err := runSearch(query, years, limit, facets)
+	if err != nil {
+		json.NewEncoder(w).Encode(searchResponse{Success: false, Query: query, Error: err.Error()})
+		return
 	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleArchiveHealth serves GET /health/archives?year=YYYY: a fast (no
+// decompression) manifest diff for that year, returned as summary counts so
+// it's cheap enough to wire into monitoring. Use the "verify" CLI
+// subcommand's -deep flag for the slower decompress-and-recheck pass.
+func handleArchiveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	yearStr := r.URL.Query().Get("year")
+	year, err := strconv.Atoi(yearStr)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": "Missing or invalid year parameter"})
+		return
+	}
+
+	summary, err := runVerify(year, cfg.ArchiveWhitelistPath, false)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "archives": summary})
+}
+
+// handleFigureMeta serves a figure's TIFF metadata - dimensions, DPI,
+// compression, and page count - without transcoding, so a caller can decide
+// whether/how to request the derived image.
+func handleFigureMeta(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	pubNumber := r.PathValue("pub")
+	numStr := r.PathValue("num")
+
+	figureNum, err := strconv.Atoi(numStr)
+	if err != nil {
+		sendError(w, "Invalid figure number")
+		return
+	}
+
+	lookup, err := lookupPatent(pubNumber)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Patent not found: %s", pubNumber))
+		return
+	}
+
+	tifData, _, err := extractTIFFromArchive(lookup, figureNum)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to extract figure: %v", err))
+		return
+	}
+
+	ifds, err := parseTIFF(tifData)
+	if err != nil {
+		sendError(w, fmt.Sprintf("Failed to read TIFF metadata: %v", err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(tiffMetaFromIFDs(ifds))
 }
 
 // handleSaveFigureDescriptions saves figure descriptions from fileApi (POST)
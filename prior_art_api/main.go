@@ -1,13 +1,32 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		runIndexCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerifyCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "match" {
+		runMatchCommand(os.Args[2:])
+		return
+	}
+
 	log.Printf("Starting Prior Art API server...")
 
 	// Initialize database connection
@@ -17,15 +36,24 @@ func main() {
 	log.Printf("Connected to database: %s@%s:%d/%s",
 		cfg.DBUser, cfg.DBHost, cfg.DBPort, cfg.DBName)
 
+	go watchForNewArchives(1 * time.Minute)
+
 	// Setup HTTP routes
 	http.HandleFunc("/", handleRoot)
 	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/health/archives", handleArchiveHealth)
+	http.HandleFunc("/api/search", handleSearch)
 
 	// Figure description endpoints (must be registered before the catch-all)
 	http.HandleFunc("GET /api/patent/{pub}/figures/{num}/image", handleFigureImage)
+	http.HandleFunc("GET /api/patent/{pub}/figures/{num}/meta", handleFigureMeta)
 	http.HandleFunc("GET /api/patent/{pub}/figures/{num}/descriptions", handleGetFigureVersions)
 	http.HandleFunc("GET /api/patent/{pub}/figures/descriptions", handleGetFigureDescriptions)
 	http.HandleFunc("POST /api/patent/{pub}/figures/descriptions", handleSaveFigureDescriptions)
+	http.HandleFunc("GET /api/patent/{pub}/bundle", handleBundleGet)
+	http.HandleFunc("POST /api/patents/bundle", handleBundlePost)
+	http.HandleFunc("POST /api/patent/compare", handleComparePatents)
+	http.HandleFunc("GET /api/patent/search", handlePatentSearch)
 
 	// Patent document endpoint (catch-all for /api/patent/{pub})
 	http.HandleFunc("/api/patent/", handleGetPatent)
@@ -39,3 +67,102 @@ func main() {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// runIndexCommand implements the "index" CLI subcommand:
+//
+//	prior_art_api index            - (re)build every year directory under cfg.ArchiveBase
+//	prior_art_api index 2016 2017   - (re)build only the given years
+//
+// It does not start the HTTP server or touch the database - it's meant to be
+// run ahead of time (or from a cron job) to warm/refresh search shards.
+func runIndexCommand(args []string) {
+	years, err := parseIndexYears(args)
+	if err != nil {
+		log.Fatalf("index: %v", err)
+	}
+
+	for _, year := range years {
+		log.Printf("index: building shard for year %d", year)
+		if err := buildYearIndex(year); err != nil {
+			log.Printf("index: year %d failed: %v", year, err)
+		}
+	}
+}
+
+func parseIndexYears(args []string) ([]int, error) {
+	if len(args) == 0 {
+		return listYearDirs()
+	}
+	years := make([]int, 0, len(args))
+	for _, a := range args {
+		y, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid year %q", a)
+		}
+		years = append(years, y)
+	}
+	return years, nil
+}
+
+// runVerifyCommand implements the "verify" CLI subcommand:
+//
+//	prior_art_api verify -year=2016
+//	prior_art_api verify -year=2016 -deep -whitelist=/etc/patents/whitelist.txt
+//
+// With no manifest yet for that year, it writes manifest-YYYY.json and
+// exits. With one already present, it diffs current archive state against it
+// and prints the summary.
+func runVerifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	year := fs.Int("year", 0, "Year to verify (required)")
+	deep := fs.Bool("deep", false, "Recompute CRC32 from decompressed bytes instead of trusting the ZIP central directory")
+	whitelist := fs.String("whitelist", cfg.ArchiveWhitelistPath, "Path to a patentDir glob whitelist file")
+	fs.Parse(args)
+
+	if *year == 0 {
+		log.Fatalf("verify: -year is required")
+	}
+
+	summary, err := runVerify(*year, *whitelist, *deep)
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+
+	log.Printf("verify: year %d - ok=%d missing=%d corrupt=%d altered=%d new=%d (total=%d)",
+		summary.Year, summary.OK, summary.Missing, summary.Corrupt, summary.Altered, summary.New, summary.Total)
+	for _, d := range summary.Details {
+		log.Printf("  %-10s %s: %s", d.Status, d.PatentDir, d.Message)
+	}
+}
+
+// runMatchCommand implements the "match" CLI subcommand:
+//
+//	prior_art_api match -out=clusters.json
+//
+// Scans the lookup DB, groups publications by title slug, runs
+// ComparePatents across every pair within a group, and writes the resulting
+// clusters to -out as JSON for manual review - see findMatchClusters.
+func runMatchCommand(args []string) {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	out := fs.String("out", "clusters.json", "Output path for the JSON cluster report")
+	fs.Parse(args)
+
+	if err := initDB(); err != nil {
+		log.Fatalf("match: %v", err)
+	}
+
+	clusters, err := findMatchClusters()
+	if err != nil {
+		log.Fatalf("match: %v", err)
+	}
+
+	data, err := json.MarshalIndent(clusters, "", "  ")
+	if err != nil {
+		log.Fatalf("match: %v", err)
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		log.Fatalf("match: %v", err)
+	}
+
+	log.Printf("match: wrote %d clusters to %s", len(clusters), *out)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// Patent match/verify: ComparePatents runs a fixed cascade of identity rules
+// against two parsed PatentDocs and stops at the first rule confident
+// enough to answer, so callers (the /api/patent/compare endpoint and the
+// "match" batch CLI below) get a consistent Status/Reason/Evidence verdict
+// instead of ad hoc similarity scoring. It's built to deduplicate
+// publications across archives, link a provisional filing to the
+// non-provisional that published it (buildRelatedApps already surfaces
+// provisionals on PatentDoc), and flag reissues/corrections that share a
+// publication number but differ in kind code.
+// ============================================================================
+
+// Status is the confidence level ComparePatents assigns a pair of
+// PatentDocs, from strongest identity signal to none.
+type Status int
+
+const (
+	Exact Status = iota
+	Strong
+	Weak
+	Ambiguous
+	Different
+)
+
+func (s Status) String() string {
+	switch s {
+	case Exact:
+		return "Exact"
+	case Strong:
+		return "Strong"
+	case Weak:
+		return "Weak"
+	case Ambiguous:
+		return "Ambiguous"
+	case Different:
+		return "Different"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// MarshalJSON renders a Status as its name rather than its int value, so API
+// responses and match-cluster reports read naturally.
+func (s Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Reason names which rule in ComparePatents' cascade produced its Status.
+type Reason int
+
+const (
+	ReasonDocNumber Reason = iota
+	ReasonSharedApplication
+	ReasonProvisionalPublished
+	ReasonTitleInventorCPC
+	ReasonTitleOnly
+	ReasonShortTitle
+	ReasonNoSignal
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonDocNumber:
+		return "DocNumber"
+	case ReasonSharedApplication:
+		return "SharedApplication"
+	case ReasonProvisionalPublished:
+		return "ProvisionalPublished"
+	case ReasonTitleInventorCPC:
+		return "TitleInventorCPC"
+	case ReasonTitleOnly:
+		return "TitleOnly"
+	case ReasonShortTitle:
+		return "ShortTitle"
+	case ReasonNoSignal:
+		return "NoSignal"
+	default:
+		return fmt.Sprintf("Reason(%d)", int(r))
+	}
+}
+
+// MarshalJSON renders a Reason as its name rather than its int value.
+func (r Reason) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// MatchResult is ComparePatents' verdict: a Status/Reason pair plus a short
+// human-readable Evidence string naming the fields the decision turned on.
+type MatchResult struct {
+	Status   Status `json:"status"`
+	Reason   Reason `json:"reason"`
+	Evidence string `json:"evidence"`
+}
+
+// ComparePatents evaluates a and b against a fixed cascade of rules, in
+// order, and returns the first rule's verdict that reaches a confident
+// answer:
+//
+//  1. identical normalized PubNumber + identical Kind                 -> Exact/DocNumber
+//  2. identical Application.Number, different PubNumber                -> Strong/SharedApplication
+//  3. a provisional RelatedApp on one side matches the other's
+//     Application.Number                                               -> Strong/ProvisionalPublished
+//  4. title slug equality but the title is too short to trust
+//     (<= 3 tokens after stopwords)                                    -> Ambiguous/ShortTitle
+//  5. title slug equality + inventor last-name Jaccard >= 0.5 +
+//     at least one shared CPC main group                               -> Strong/TitleInventorCPC
+//  6. title slug equality alone                                        -> Weak/TitleOnly
+//  7. no rule matched                                                  -> Different/NoSignal
+func ComparePatents(a, b *PatentDoc) MatchResult {
+	if normalizePubNumber(a.PubNumber) == normalizePubNumber(b.PubNumber) && a.Kind == b.Kind {
+		return MatchResult{Exact, ReasonDocNumber, fmt.Sprintf("pub_number=%s kind=%s", normalizePubNumber(a.PubNumber), a.Kind)}
+	}
+
+	if a.Application.Number != "" && a.Application.Number == b.Application.Number {
+		return MatchResult{Strong, ReasonSharedApplication, fmt.Sprintf("application_number=%s", a.Application.Number)}
+	}
+
+	if evidence, ok := provisionalLink(a, b); ok {
+		return MatchResult{Strong, ReasonProvisionalPublished, evidence}
+	}
+
+	aSlug, bSlug := titleSlug(a.Title), titleSlug(b.Title)
+	if aSlug == "" || aSlug != bSlug {
+		return MatchResult{Different, ReasonNoSignal, "no matching signal"}
+	}
+
+	if tokens := titleTokens(a.Title); len(tokens) <= 3 {
+		return MatchResult{Ambiguous, ReasonShortTitle, fmt.Sprintf("title_slug=%q tokens=%d", aSlug, len(tokens))}
+	}
+
+	inventorSim := jaccard(lastNameSet(a.Inventors), lastNameSet(b.Inventors))
+	sharedCPC := sharedCPCMainGroups(a.Classifications.CPC, b.Classifications.CPC)
+
+	if inventorSim >= 0.5 && len(sharedCPC) > 0 {
+		return MatchResult{Strong, ReasonTitleInventorCPC, fmt.Sprintf(
+			"title_slug=%q inventor_jaccard=%.2f shared_cpc=%s", aSlug, inventorSim, strings.Join(sharedCPC, ","))}
+	}
+
+	return MatchResult{Weak, ReasonTitleOnly, fmt.Sprintf("title_slug=%q inventor_jaccard=%.2f", aSlug, inventorSim)}
+}
+
+// provisionalLink checks both directions for a provisional RelatedApp on
+// one PatentDoc whose Number matches the other's Application.Number.
+func provisionalLink(a, b *PatentDoc) (string, bool) {
+	if num, ok := matchingProvisional(a.RelatedApps, b.Application.Number); ok {
+		return fmt.Sprintf("provisional=%s matches application_number=%s", num, b.Application.Number), true
+	}
+	if num, ok := matchingProvisional(b.RelatedApps, a.Application.Number); ok {
+		return fmt.Sprintf("provisional=%s matches application_number=%s", num, a.Application.Number), true
+	}
+	return "", false
+}
+
+func matchingProvisional(related []RelatedApp, appNumber string) (string, bool) {
+	if appNumber == "" {
+		return "", false
+	}
+	for _, r := range related {
+		if r.Type == "provisional" && r.Number == appNumber {
+			return r.Number, true
+		}
+	}
+	return "", false
+}
+
+var titleSlugNonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+
+// titleSlug lowercases title, replaces every run of non-alphanumeric
+// characters with a single space, and trims the result.
+func titleSlug(title string) string {
+	return strings.Join(strings.Fields(titleSlugNonAlnum.ReplaceAllString(strings.ToLower(title), " ")), " ")
+}
+
+// titleStopwords is stripped out before titleTokens' short-title check, so
+// "A Method For Widgets" doesn't get credit for four tokens it doesn't
+// meaningfully have.
+var titleStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "for": true, "of": true,
+	"the": true, "with": true, "to": true, "in": true, "on": true, "or": true,
+}
+
+func titleTokens(title string) []string {
+	var tokens []string
+	for _, word := range strings.Fields(titleSlug(title)) {
+		if !titleStopwords[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// lastNameSet builds the set of inventors' last names (lowercased) for
+// jaccard. InventorInfo only carries a combined Name, so the last
+// whitespace-separated token stands in for the surname.
+func lastNameSet(inventors []InventorInfo) map[string]bool {
+	set := make(map[string]bool, len(inventors))
+	for _, inv := range inventors {
+		fields := strings.Fields(inv.Name)
+		if len(fields) == 0 {
+			continue
+		}
+		set[strings.ToLower(fields[len(fields)-1])] = true
+	}
+	return set
+}
+
+// jaccard is the intersection-over-union similarity of two sets.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	intersection := 0
+	union := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		union[k] = true
+		if b[k] {
+			intersection++
+		}
+	}
+	for k := range b {
+		union[k] = true
+	}
+	return float64(intersection) / float64(len(union))
+}
+
+// cpcMainGroup strips formatClassification's separating space and returns
+// the portion before "/" - e.g. "A61B 17/00" -> "A61B17".
+func cpcMainGroup(cpc string) string {
+	cpc = strings.ReplaceAll(cpc, " ", "")
+	if idx := strings.Index(cpc, "/"); idx >= 0 {
+		return cpc[:idx]
+	}
+	return cpc
+}
+
+// sharedCPCMainGroups returns the CPC main groups (deduplicated, sorted)
+// present in both a and b.
+func sharedCPCMainGroups(a, b []string) []string {
+	bGroups := make(map[string]bool, len(b))
+	for _, cpc := range b {
+		bGroups[cpcMainGroup(cpc)] = true
+	}
+
+	seen := make(map[string]bool)
+	var shared []string
+	for _, cpc := range a {
+		group := cpcMainGroup(cpc)
+		if bGroups[group] && !seen[group] {
+			seen[group] = true
+			shared = append(shared, group)
+		}
+	}
+	sort.Strings(shared)
+	return shared
+}
+
+// loadPatentDoc runs the same lookup/extract/parse pipeline handleGetPatent
+// uses, for callers (the compare endpoint, the match CLI) that need a full
+// PatentDoc rather than just the raw_xml_path.
+func loadPatentDoc(pubNumber string) (*PatentDoc, error) {
+	lookup, err := lookupPatent(pubNumber)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s: %w", pubNumber, err)
+	}
+	extracted, err := extractFromArchive(lookup)
+	if err != nil {
+		return nil, fmt.Errorf("extract %s: %w", pubNumber, err)
+	}
+	return parsePatentXML(extracted.XMLData, extracted, lookup)
+}
+
+// MatchCluster groups publications whose titles slug-matched in a "match"
+// batch run, with every pairwise ComparePatents verdict within the group,
+// for a human to review and confirm or reject.
+type MatchCluster struct {
+	TitleSlug  string      `json:"title_slug"`
+	PubNumbers []string    `json:"pub_numbers"`
+	Pairs      []MatchPair `json:"pairs"`
+}
+
+// MatchPair is one pairwise verdict inside a MatchCluster.
+type MatchPair struct {
+	PubA     string `json:"pub_a"`
+	PubB     string `json:"pub_b"`
+	Status   Status `json:"status"`
+	Reason   Reason `json:"reason"`
+	Evidence string `json:"evidence"`
+}
+
+// findMatchClusters scans the lookup DB and groups publications by title
+// slug - cheap, since it only needs the title column - then loads the full
+// PatentDoc for every publication in a group with more than one candidate
+// and runs ComparePatents across every pair. The slug grouping is what
+// keeps this from degenerating into an O(n^2) scan across the whole table.
+func findMatchClusters() ([]MatchCluster, error) {
+	lookups, err := listAllPatents()
+	if err != nil {
+		return nil, fmt.Errorf("listing patents: %w", err)
+	}
+
+	groups := make(map[string][]PatentLookup)
+	for _, l := range lookups {
+		slug := titleSlug(l.Title)
+		if slug == "" {
+			continue
+		}
+		groups[slug] = append(groups[slug], l)
+	}
+
+	var clusters []MatchCluster
+	for slug, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+
+		var docs []*PatentDoc
+		var pubs []string
+		for _, l := range group {
+			doc, err := loadPatentDoc(l.PubNumber)
+			if err != nil {
+				log.Printf("match: skipping %s: %v", l.PubNumber, err)
+				continue
+			}
+			docs = append(docs, doc)
+			pubs = append(pubs, l.PubNumber)
+		}
+		if len(docs) < 2 {
+			continue
+		}
+
+		cluster := MatchCluster{TitleSlug: slug, PubNumbers: pubs}
+		for i := 0; i < len(docs); i++ {
+			for j := i + 1; j < len(docs); j++ {
+				result := ComparePatents(docs[i], docs[j])
+				cluster.Pairs = append(cluster.Pairs, MatchPair{
+					PubA: pubs[i], PubB: pubs[j],
+					Status: result.Status, Reason: result.Reason, Evidence: result.Evidence,
+				})
+			}
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].TitleSlug < clusters[j].TitleSlug })
+	return clusters, nil
+}
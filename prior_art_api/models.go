@@ -246,11 +246,34 @@ type DrawingInfo struct {
 	Path string `json:"path"`
 }
 
-// DescPara holds description paragraph information
+// DescPara holds description paragraph information. Text is always the
+// flattened plain-text form for backwards compatibility; Refs and Style are
+// optional rich-text annotations over Text's byte offsets, populated by the
+// streaming parser in description.go (nil/omitted for callers that don't
+// need them).
 type DescPara struct {
-	Type string `json:"type"`
-	Num  int    `json:"num,omitempty"`
-	Text string `json:"text"`
+	Type  string     `json:"type"`
+	Num   int        `json:"num,omitempty"`
+	Text  string     `json:"text"`
+	Refs  []Ref      `json:"refs,omitempty"`
+	Style []StyleRun `json:"style,omitempty"`
+}
+
+// Ref is a figure or claim cross-reference found inside a description
+// paragraph (e.g. <figref>, <claim-ref>), with the byte offset into the
+// paragraph's Text it occurred at.
+type Ref struct {
+	Type   string `json:"type"` // "figure" or "claim"
+	Target string `json:"target"`
+	Offset int    `json:"offset"`
+}
+
+// StyleRun marks a half-open [Start, End) byte range of a paragraph's Text
+// that should render in the given style - "italic", "bold", "sub", or "sup".
+type StyleRun struct {
+	Style string `json:"style"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
 }
 
 // ClaimInfo holds claim information
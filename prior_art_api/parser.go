@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/xml"
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -157,77 +156,6 @@ func buildDescription(doc *PatentDoc, patent *USPatentApplication) {
 	doc.Description = parseDescriptionContent(content)
 }
 
-// descMatch holds match information for description parsing
-type descMatch struct {
-	pos    int
-	isHead bool
-	num    int
-	text   string
-}
-
-// parseDescriptionContent parses description XML content into structured paragraphs
-func parseDescriptionContent(content string) []DescPara {
-	var result []DescPara
-
-	// Regex patterns for headings and paragraphs
-	headingRe := regexp.MustCompile(`<heading[^>]*>([^<]*)</heading>`)
-	paraRe := regexp.MustCompile(`<p[^>]*num="(\d+)"[^>]*>(.*?)</p>`)
-
-	// Collect matches with positions for sorting
-	var matches []descMatch
-
-	// Find headings
-	for _, m := range headingRe.FindAllStringSubmatchIndex(content, -1) {
-		text := content[m[2]:m[3]]
-		matches = append(matches, descMatch{
-			pos:    m[0],
-			isHead: true,
-			text:   strings.TrimSpace(text),
-		})
-	}
-
-	// Find paragraphs with num attribute
-	for _, m := range paraRe.FindAllStringSubmatchIndex(content, -1) {
-		numStr := content[m[2]:m[3]]
-		text := content[m[4]:m[5]]
-		num, _ := strconv.Atoi(numStr)
-		cleanText := cleanXMLText([]byte(text))
-		if cleanText != "" {
-			matches = append(matches, descMatch{
-				pos:    m[0],
-				isHead: false,
-				num:    num,
-				text:   cleanText,
-			})
-		}
-	}
-
-	// Sort by position in document
-	sortDescMatches(matches)
-
-	// Build result
-	for _, m := range matches {
-		if m.isHead {
-			result = append(result, DescPara{Type: "heading", Text: m.text})
-		} else {
-			result = append(result, DescPara{Type: "paragraph", Num: m.num, Text: m.text})
-		}
-	}
-
-	return result
-}
-
-// sortDescMatches sorts description matches by position using simple bubble sort
-func sortDescMatches(matches []descMatch) {
-	for i := 0; i < len(matches)-1; i++ {
-		for j := i + 1; j < len(matches); j++ {
-			if matches[j].pos < matches[i].pos {
-				matches[i], matches[j] = matches[j], matches[i]
-			}
-		}
-	}
-}
-
 // buildClaims extracts patent claims
 func buildClaims(doc *PatentDoc, patent *USPatentApplication) {
 	for _, claim := range patent.Claims.Items {
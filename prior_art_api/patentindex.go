@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// ============================================================================
+// patents.idx reader: the same fixed-width format BuildIndex in the
+// diagnostic analyzer produces for ZIP-based originals archives. This side
+// only ever reads it, and is entirely optional - lookupXMLFromIndex returns
+// ok=false whenever cfg.IndexPath is unset or the index doesn't have the
+// patent, and the caller falls back to the normal TAR/ZIP walk.
+// ============================================================================
+
+const (
+	idxMagic      = "PATIDX01"
+	idxHeaderSize = 8 + 4 + 4 + 4 // magic + version + record count + crc32(body)
+
+	idxPubNumberLen = 20
+	idxPathLen      = 256
+	idxEntryNameLen = 128
+	idxDTDLen       = 16
+	idxRecordSize   = idxPubNumberLen + idxPathLen + idxEntryNameLen*2 + idxDTDLen + 8*6 + 2*2
+)
+
+type indexRecord struct {
+	pubNumber           string
+	outerArchivePath    string
+	nestedZipName       string
+	nestedZipOffset     int64
+	nestedZipCompSize   int64
+	nestedZipUncompSize int64
+	nestedZipMethod     uint16
+	xmlEntryName        string
+	xmlOffset           int64
+	xmlCompSize         int64
+	xmlUncompSize       int64
+	xmlMethod           uint16
+}
+
+func decodeIndexRecord(buf []byte) indexRecord {
+	o := 0
+	readString := func(width int) string {
+		s := string(bytes.TrimRight(buf[o:o+width], "\x00"))
+		o += width
+		return s
+	}
+
+	r := indexRecord{
+		pubNumber:        readString(idxPubNumberLen),
+		outerArchivePath: readString(idxPathLen),
+		nestedZipName:    readString(idxEntryNameLen),
+		xmlEntryName:     readString(idxEntryNameLen),
+	}
+	o += idxDTDLen // DTD version isn't needed on the read side here
+
+	readInt64 := func() int64 {
+		v := int64(binary.BigEndian.Uint64(buf[o:]))
+		o += 8
+		return v
+	}
+	r.nestedZipOffset = readInt64()
+	r.nestedZipCompSize = readInt64()
+	r.nestedZipUncompSize = readInt64()
+	r.xmlOffset = readInt64()
+	r.xmlCompSize = readInt64()
+	r.xmlUncompSize = readInt64()
+
+	r.nestedZipMethod = binary.BigEndian.Uint16(buf[o:])
+	o += 2
+	r.xmlMethod = binary.BigEndian.Uint16(buf[o:])
+	o += 2
+
+	return r
+}
+
+var (
+	patentIndexOnce sync.Once
+	patentIndexData []byte
+	patentIndexErr  error
+)
+
+// loadPatentIndex mmaps cfg.IndexPath once per process and validates its
+// header (magic, and a CRC32 of the body so a truncated or stale index is
+// rejected rather than trusted).
+func loadPatentIndex() ([]byte, error) {
+	patentIndexOnce.Do(func() {
+		if cfg.IndexPath == "" {
+			patentIndexErr = fmt.Errorf("no index configured")
+			return
+		}
+
+		f, err := os.Open(cfg.IndexPath)
+		if err != nil {
+			patentIndexErr = err
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			patentIndexErr = err
+			return
+		}
+
+		data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			patentIndexErr = fmt.Errorf("mmap %s: %w", cfg.IndexPath, err)
+			return
+		}
+
+		if len(data) < idxHeaderSize || string(data[:8]) != idxMagic {
+			patentIndexErr = fmt.Errorf("%s: bad magic, index may be corrupt or stale", cfg.IndexPath)
+			return
+		}
+
+		count := binary.BigEndian.Uint32(data[12:16])
+		wantCRC := binary.BigEndian.Uint32(data[16:20])
+		body := data[idxHeaderSize:]
+		if uint64(len(body)) != uint64(count)*uint64(idxRecordSize) {
+			patentIndexErr = fmt.Errorf("%s: record count does not match body length", cfg.IndexPath)
+			return
+		}
+		if crc32.ChecksumIEEE(body) != wantCRC {
+			patentIndexErr = fmt.Errorf("%s: CRC32 mismatch, index is corrupt", cfg.IndexPath)
+			return
+		}
+
+		patentIndexData = data
+	})
+	return patentIndexData, patentIndexErr
+}
+
+func patentIndexRecordAt(data []byte, i int) indexRecord {
+	off := idxHeaderSize + i*idxRecordSize
+	return decodeIndexRecord(data[off : off+idxRecordSize])
+}
+
+func decompressEntryAt(ra io.ReaderAt, offset, compSize, uncompSize int64, method uint16) ([]byte, error) {
+	sr := io.NewSectionReader(ra, offset, compSize)
+
+	switch method {
+	case 0: // zip.Store
+		data := make([]byte, uncompSize)
+		if _, err := io.ReadFull(sr, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case 8: // zip.Deflate
+		fr := flate.NewReader(sr)
+		defer fr.Close()
+		return ioutil.ReadAll(fr)
+	default:
+		return nil, fmt.Errorf("unsupported compression method %d", method)
+	}
+}
+
+// lookupXMLFromIndex binary-searches patents.idx for pubNumber and, on a hit,
+// decompresses just the nested ZIP and XML entry it points to. ok is false
+// whenever no index is configured or the patent isn't in it, in which case
+// the caller should fall back to extractFromArchive's TAR/ZIP walk.
+func lookupXMLFromIndex(pubNumber string) (data []byte, ok bool) {
+	idx, err := loadPatentIndex()
+	if err != nil {
+		return nil, false
+	}
+
+	count := (len(idx) - idxHeaderSize) / idxRecordSize
+	i := sort.Search(count, func(i int) bool {
+		return patentIndexRecordAt(idx, i).pubNumber >= pubNumber
+	})
+	if i >= count {
+		return nil, false
+	}
+	rec := patentIndexRecordAt(idx, i)
+	if rec.pubNumber != pubNumber {
+		return nil, false
+	}
+
+	outer, err := os.Open(rec.outerArchivePath)
+	if err != nil {
+		return nil, false
+	}
+	defer outer.Close()
+
+	nestedData, err := decompressEntryAt(outer, rec.nestedZipOffset, rec.nestedZipCompSize, rec.nestedZipUncompSize, rec.nestedZipMethod)
+	if err != nil {
+		return nil, false
+	}
+
+	xmlData, err := decompressEntryAt(bytes.NewReader(nestedData), rec.xmlOffset, rec.xmlCompSize, rec.xmlUncompSize, rec.xmlMethod)
+	if err != nil {
+		return nil, false
+	}
+
+	return xmlData, true
+}
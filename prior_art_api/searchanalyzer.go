@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ============================================================================
+// Domain-aware analyzer shared by the indexer (searchindex.go) and the query
+// parser (searchquery.go). Kept in its own file since both sides need it and
+// neither owns it.
+// ============================================================================
+
+// analyzerTokenPattern's first alternative matches publication/application numbers like
+// "US20030046754A1" so the tokenizer keeps them whole instead of splitting
+// them into "us20030046754a1" fragments (or worse, breaking on the digit/letter
+// boundary). Everything else falls through to the generic word pattern.
+var analyzerTokenPattern = regexp.MustCompile(`[A-Za-z]{2}\d{7,}[A-Z]\d{0,2}|[A-Za-z0-9]+`)
+
+// analyzeText lowercases and tokenizes free text, keeping patent numbers
+// whole. It's used for every free-text field (title, abstract, description,
+// claims, inventor/applicant names).
+func analyzeText(s string) []string {
+	matches := analyzerTokenPattern.FindAllString(s, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	tokens := make([]string, len(matches))
+	for i, m := range matches {
+		tokens[i] = strings.ToLower(m)
+	}
+	return tokens
+}
+
+// cpcCodeString renders a CPC classification as a single faceted term, e.g.
+// "h04l29/06". CPC/IPC codes are indexed whole, the same way patent numbers
+// are - splitting "h04l" and "29/06" into separate tokens would make them
+// unsearchable as a code.
+func cpcCodeString(c ClassificationCPC) string {
+	code := fmt.Sprintf("%s%s%s%s/%s", c.Section, c.Class, c.Subclass, c.MainGroup, c.Subgroup)
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(code), "/"))
+}
+
+// ipcCodeString renders an IPC classification the same way.
+func ipcCodeString(c ClassificationIPCR) string {
+	code := fmt.Sprintf("%s%s%s%s/%s", c.Section, c.Class, c.Subclass, c.MainGroup, c.Subgroup)
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(code), "/"))
+}
+
+// joinParagraphs concatenates abstract/description paragraph text with the
+// XML tags stripped, for tokenization.
+func joinParagraphs(paras []Paragraph) string {
+	var b strings.Builder
+	for _, p := range paras {
+		b.WriteString(cleanXMLText(p.Text))
+		b.WriteString(" ")
+	}
+	return b.String()
+}
+
+// joinClaims concatenates claim text with the XML tags stripped.
+func joinClaims(claims []Claim) string {
+	var b strings.Builder
+	for _, c := range claims {
+		b.WriteString(cleanXMLText(c.Text))
+		b.WriteString(" ")
+	}
+	return b.String()
+}
+
+// joinNames concatenates inventor/applicant names into one string for the
+// "inventor:" field.
+func joinNames(inventors []Inventor, applicants []Applicant) string {
+	var b strings.Builder
+	for _, inv := range inventors {
+		b.WriteString(inv.FirstName)
+		b.WriteString(" ")
+		b.WriteString(inv.LastName)
+		b.WriteString(" ")
+	}
+	for _, ap := range applicants {
+		b.WriteString(ap.FirstName)
+		b.WriteString(" ")
+		b.WriteString(ap.LastName)
+		b.WriteString(" ")
+		b.WriteString(ap.OrgName)
+		b.WriteString(" ")
+	}
+	return b.String()
+}
+
+// truncateText cuts s to at most n runes, used to keep stored summaries (and
+// hence shard file size) bounded - the shard stores enough of the abstract to
+// render a snippet, not the whole document.
+func truncateText(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Full-text search index: one shard per year under cfg.ArchiveBase/.index/,
+// built by buildYearIndex and queried by Searcher (searchquery.go). This file
+// owns the shard format and the indexer that walks the TAR archives to build
+// it; searchquery.go owns query parsing and ranking.
+//
+// A shard is loaded fully into memory (it covers one year of patents, not the
+// whole corpus), so the on-disk format favors simple sequential encoding over
+// the random-access layout tarindex.go uses for its much larger TAR offset
+// index - there's no ReadAt path here.
+// ============================================================================
+
+const (
+	searchShardMagic   = "PSHARD01"
+	searchShardVersion = 1
+
+	// searchIndexDirName is the subdirectory (under cfg.ArchiveBase) holding
+	// shard files, named "<year>.shard".
+	searchIndexDirName = ".index"
+
+	// summaryMaxRunes/titleMaxRunes bound how much of the abstract/title each
+	// shard stores for snippet rendering - see truncateText.
+	summaryMaxRunes = 400
+	titleMaxRunes   = 200
+)
+
+// shardDoc is the per-document record stored in a shard: everything the
+// Searcher needs to render a hit and compute facets, without re-reading the
+// archive.
+type shardDoc struct {
+	pubNumber string
+	year      int
+	title     string
+	summary   string
+	docLen    int // token count of the unscoped stream, for BM25 length normalization
+	cpcCodes  []string
+}
+
+// postingEntry is one term's postings list: parallel docIDs/freqs/positions
+// slices, sorted by docID ascending.
+type postingEntry struct {
+	docIDs    []int32
+	freqs     []int32
+	positions [][]int32 // positions[i] are the token positions of this term in docIDs[i]
+}
+
+// indexShard is a single year's worth of postings, fully resident in memory
+// once loaded.
+type indexShard struct {
+	year      int
+	docs      []shardDoc
+	terms     map[string]*postingEntry
+	avgDocLen float64
+}
+
+func searchIndexDir() string {
+	return filepath.Join(cfg.ArchiveBase, searchIndexDirName)
+}
+
+func shardPath(year int) string {
+	return filepath.Join(searchIndexDir(), fmt.Sprintf("%d.shard", year))
+}
+
+// ----------------------------------------------------------------------------
+// Shard builder - accumulates postings in memory while the indexer walks a
+// year's TAR archives, then finalizes into an indexShard.
+// ----------------------------------------------------------------------------
+
+type shardBuilder struct {
+	year int
+	docs []shardDoc
+	// terms maps a term key (plain "widget" or field-scoped "title:widget")
+	// to docID -> token positions within that term's own stream.
+	terms map[string]map[int32][]int32
+}
+
+func newShardBuilder(year int) *shardBuilder {
+	return &shardBuilder{year: year, terms: make(map[string]map[int32][]int32)}
+}
+
+func (b *shardBuilder) addDoc(doc shardDoc) int32 {
+	docID := int32(len(b.docs))
+	b.docs = append(b.docs, doc)
+	return docID
+}
+
+func (b *shardBuilder) addToken(term string, docID int32, pos int) {
+	if term == "" {
+		return
+	}
+	postings, ok := b.terms[term]
+	if !ok {
+		postings = make(map[int32][]int32)
+		b.terms[term] = postings
+	}
+	postings[docID] = append(postings[docID], int32(pos))
+}
+
+// addField tokenizes text and records both the unscoped term (searchable via
+// a bare "q=") and, when fieldName != "", the field-scoped term
+// "fieldName:term" (searchable via "q=fieldName:term"). unscopedPos is the
+// running position in the doc's combined unscoped stream (title, abstract,
+// description, claims, inventors back to back, in that order - see
+// indexPatent) so that a phrase query without a field prefix can't get a
+// false "consecutive positions" match between e.g. the last word of the
+// title and the first word of the abstract just because both fields'
+// position counters happened to collide. The field-scoped stream keeps its
+// own 0-based counter, since a field-scoped phrase query only ever looks
+// within that one field.
+func (b *shardBuilder) addField(fieldName, text string, docID int32, unscopedPos int) int {
+	tokens := analyzeText(text)
+	for i, tok := range tokens {
+		b.addToken(tok, docID, unscopedPos+i)
+		if fieldName != "" {
+			b.addToken(fieldName+":"+tok, docID, i)
+		}
+	}
+	return len(tokens)
+}
+
+// addCode indexes a whole, untokenized code (CPC/IPC) under its own
+// field-scoped term plus the unscoped stream, so "cpc:h04l29/06" and a bare
+// "h04l29/06" both find it. fieldPos/unscopedPos are this code's position
+// within the field-scoped and unscoped streams respectively (see addField).
+func (b *shardBuilder) addCode(fieldName, code string, docID int32, fieldPos, unscopedPos int) {
+	if code == "" {
+		return
+	}
+	b.addToken(code, docID, unscopedPos)
+	b.addToken(fieldName+":"+code, docID, fieldPos)
+}
+
+// finalize sorts each term's postings by docID and computes avgDocLen.
+func (b *shardBuilder) finalize() *indexShard {
+	shard := &indexShard{
+		year:  b.year,
+		docs:  b.docs,
+		terms: make(map[string]*postingEntry, len(b.terms)),
+	}
+
+	var totalLen int
+	for _, d := range b.docs {
+		totalLen += d.docLen
+	}
+	if len(b.docs) > 0 {
+		shard.avgDocLen = float64(totalLen) / float64(len(b.docs))
+	}
+
+	for term, byDoc := range b.terms {
+		docIDs := make([]int32, 0, len(byDoc))
+		for docID := range byDoc {
+			docIDs = append(docIDs, docID)
+		}
+		sort.Slice(docIDs, func(i, j int) bool { return docIDs[i] < docIDs[j] })
+
+		entry := &postingEntry{
+			docIDs:    docIDs,
+			freqs:     make([]int32, len(docIDs)),
+			positions: make([][]int32, len(docIDs)),
+		}
+		for i, docID := range docIDs {
+			positions := byDoc[docID]
+			sort.Slice(positions, func(a, c int) bool { return positions[a] < positions[c] })
+			entry.freqs[i] = int32(len(positions))
+			entry.positions[i] = positions
+		}
+		shard.terms[term] = entry
+	}
+
+	return shard
+}
+
+// ----------------------------------------------------------------------------
+// Indexing a single patent's parsed XML into a shard builder
+// ----------------------------------------------------------------------------
+
+// indexPatent tokenizes one already-parsed patent into builder, returning the
+// pubNumber it was indexed under.
+func indexPatent(b *shardBuilder, patent *USPatentApplication, year int, fallbackPubNumber string) string {
+	pubNumber := patent.BibData.PublicationRef.DocID.DocNumber
+	if pubNumber == "" {
+		pubNumber = fallbackPubNumber
+	}
+
+	doc := shardDoc{
+		pubNumber: pubNumber,
+		year:      year,
+		title:     truncateText(patent.BibData.InventionTitle, titleMaxRunes),
+	}
+	docID := b.addDoc(doc)
+
+	unscopedPos := 0
+	unscopedPos += b.addField("title", patent.BibData.InventionTitle, docID, unscopedPos)
+
+	abstractText := joinParagraphs(patent.Abstract.Paragraphs)
+	b.docs[docID].summary = truncateText(strings.TrimSpace(abstractText), summaryMaxRunes)
+	unscopedPos += b.addField("", abstractText, docID, unscopedPos)
+
+	unscopedPos += b.addField("", cleanXMLText(patent.Description.Content), docID, unscopedPos)
+	unscopedPos += b.addField("claim", joinClaims(patent.Claims.Items), docID, unscopedPos)
+	unscopedPos += b.addField("inventor", joinNames(patent.BibData.USParties.Inventors, patent.BibData.USParties.Applicants), docID, unscopedPos)
+
+	cpcPos := 0
+	var cpcCodes []string
+	addCPC := func(code string) {
+		if code == "" {
+			return
+		}
+		b.addCode("cpc", code, docID, cpcPos, unscopedPos)
+		cpcCodes = append(cpcCodes, code)
+		cpcPos++
+		unscopedPos++
+	}
+	for _, c := range patent.BibData.CPCClassifications.Main {
+		addCPC(cpcCodeString(c))
+	}
+	for _, c := range patent.BibData.CPCClassifications.Other {
+		addCPC(cpcCodeString(c))
+	}
+	for _, c := range patent.BibData.Classifications.Items {
+		addCPC(ipcCodeString(c))
+	}
+	b.docs[docID].cpcCodes = cpcCodes
+	b.docs[docID].docLen = unscopedPos
+
+	return pubNumber
+}
+
+// ----------------------------------------------------------------------------
+// Building a year's shard from its TAR archives
+// ----------------------------------------------------------------------------
+
+// buildYearIndex walks every TAR archive under cfg.ArchiveBase/<year>/,
+// extracts and parses each patent's XML the same way extractFromArchive does
+// at request time, and writes the resulting shard to disk.
+func buildYearIndex(year int) error {
+	yearDir := filepath.Join(cfg.ArchiveBase, strconv.Itoa(year))
+	tarPaths, err := listTarFiles(yearDir)
+	if err != nil {
+		return fmt.Errorf("listing TAR files in %s: %w", yearDir, err)
+	}
+	if len(tarPaths) == 0 {
+		return fmt.Errorf("no TAR archives found in %s", yearDir)
+	}
+
+	builder := newShardBuilder(year)
+	for _, tarPath := range tarPaths {
+		if err := indexTarArchive(builder, tarPath, year); err != nil {
+			return fmt.Errorf("indexing %s: %w", tarPath, err)
+		}
+	}
+
+	shard := builder.finalize()
+	log.Printf("Indexed %d patents from %d archive(s) for year %d", len(shard.docs), len(tarPaths), year)
+	return writeShard(shard)
+}
+
+// listTarFiles returns every *.tar/*.TAR file directly under dir.
+func listTarFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.EqualFold(filepath.Ext(e.Name()), ".tar") {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// indexTarArchive streams one TAR archive, extracting and indexing every
+// patent ZIP member it contains.
+func indexTarArchive(builder *shardBuilder, tarPath string, year int) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading TAR: %w", err)
+		}
+
+		if !strings.HasSuffix(strings.ToUpper(header.Name), ".ZIP") {
+			continue
+		}
+
+		zipData, err := io.ReadAll(tr)
+		if err != nil {
+			log.Printf("skip %s: reading ZIP member: %v", header.Name, err)
+			continue
+		}
+
+		base := filepath.Base(header.Name)
+		fallbackPubNumber := strings.TrimSuffix(base, filepath.Ext(base))
+
+		if err := indexZIPMember(builder, zipData, year, fallbackPubNumber); err != nil {
+			log.Printf("skip %s: %v", header.Name, err)
+		}
+	}
+	return nil
+}
+
+// indexZIPMember finds the one XML file in a patent's ZIP, parses it, and
+// indexes it.
+func indexZIPMember(builder *shardBuilder, zipData []byte, year int, fallbackPubNumber string) error {
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return fmt.Errorf("opening ZIP: %w", err)
+	}
+
+	for _, file := range zr.File {
+		if !strings.HasSuffix(strings.ToUpper(file.Name), ".XML") {
+			continue
+		}
+		xmlData, err := readZIPFile(file)
+		if err != nil {
+			return fmt.Errorf("reading XML: %w", err)
+		}
+
+		var patent USPatentApplication
+		if err := xml.Unmarshal(xmlData, &patent); err != nil {
+			return fmt.Errorf("parsing XML: %w", err)
+		}
+
+		indexPatent(builder, &patent, year, fallbackPubNumber)
+		return nil
+	}
+	return fmt.Errorf("no XML file in ZIP")
+}
+
+// ----------------------------------------------------------------------------
+// Shard (de)serialization
+//
+// Layout: an 8-byte magic, a uint32 version, a uint32 year, a uint32 docCount,
+// a uint32 termCount, a uint32 CRC32 of everything that follows, then the
+// body (doc table, then term dictionary+postings). Every variable-length
+// value - strings, postings lists, position lists - is length/count-prefixed
+// with a uvarint; doc IDs and positions within a posting are delta-encoded
+// uvarints, matching how tarindex.go treats its own offsets as "write once,
+// read sequentially" rather than needing random access mid-structure.
+// ----------------------------------------------------------------------------
+
+func writeShard(shard *indexShard) error {
+	dir := searchIndexDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating index dir: %w", err)
+	}
+
+	var body bytes.Buffer
+	if err := writeShardDocs(&body, shard.docs); err != nil {
+		return err
+	}
+	if err := writeShardTerms(&body, shard.terms); err != nil {
+		return err
+	}
+
+	path := shardPath(shard.year)
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("creating shard tmp file: %w", err)
+	}
+
+	header := make([]byte, 8+4+4+4+4+4)
+	copy(header[0:8], searchShardMagic)
+	binary.BigEndian.PutUint32(header[8:12], searchShardVersion)
+	binary.BigEndian.PutUint32(header[12:16], uint32(shard.year))
+	binary.BigEndian.PutUint32(header[16:20], uint32(len(shard.docs)))
+	binary.BigEndian.PutUint32(header[20:24], uint32(len(shard.terms)))
+	binary.BigEndian.PutUint32(header[24:28], crc32.ChecksumIEEE(body.Bytes()))
+
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing shard header: %w", err)
+	}
+	if _, err := f.Write(body.Bytes()); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing shard body: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func writeUvarintString(buf *bytes.Buffer, s string) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(s)))
+	buf.Write(lenBuf[:n])
+	buf.WriteString(s)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func writeShardDocs(buf *bytes.Buffer, docs []shardDoc) error {
+	for _, d := range docs {
+		writeUvarintString(buf, d.pubNumber)
+		writeUvarint(buf, uint64(d.year))
+		writeUvarint(buf, uint64(d.docLen))
+		writeUvarintString(buf, d.title)
+		writeUvarintString(buf, d.summary)
+		writeUvarint(buf, uint64(len(d.cpcCodes)))
+		for _, code := range d.cpcCodes {
+			writeUvarintString(buf, code)
+		}
+	}
+	return nil
+}
+
+func writeShardTerms(buf *bytes.Buffer, terms map[string]*postingEntry) error {
+	sortedTerms := make([]string, 0, len(terms))
+	for term := range terms {
+		sortedTerms = append(sortedTerms, term)
+	}
+	sort.Strings(sortedTerms)
+
+	for _, term := range sortedTerms {
+		entry := terms[term]
+		writeUvarintString(buf, term)
+		writeUvarint(buf, uint64(len(entry.docIDs)))
+
+		var prevDocID int32
+		for i, docID := range entry.docIDs {
+			writeUvarint(buf, uint64(docID-prevDocID))
+			prevDocID = docID
+
+			writeUvarint(buf, uint64(entry.freqs[i]))
+			positions := entry.positions[i]
+			writeUvarint(buf, uint64(len(positions)))
+			var prevPos int32
+			for _, p := range positions {
+				writeUvarint(buf, uint64(p-prevPos))
+				prevPos = p
+			}
+		}
+	}
+	return nil
+}
+
+// loadShard reads and fully decodes a shard file into memory.
+func loadShard(year int) (*indexShard, error) {
+	path := shardPath(year)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 28 || string(data[0:8]) != searchShardMagic {
+		return nil, fmt.Errorf("%s: not a valid search shard", path)
+	}
+
+	docCount := binary.BigEndian.Uint32(data[16:20])
+	termCount := binary.BigEndian.Uint32(data[20:24])
+	wantCRC := binary.BigEndian.Uint32(data[24:28])
+	body := data[28:]
+	if gotCRC := crc32.ChecksumIEEE(body); gotCRC != wantCRC {
+		return nil, fmt.Errorf("%s: CRC32 mismatch (corrupt shard)", path)
+	}
+
+	r := bufio.NewReader(bytes.NewReader(body))
+	shard := &indexShard{
+		year:  year,
+		docs:  make([]shardDoc, docCount),
+		terms: make(map[string]*postingEntry, termCount),
+	}
+
+	var totalLen uint64
+	for i := uint32(0); i < docCount; i++ {
+		d, err := readShardDoc(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading doc %d: %w", path, i, err)
+		}
+		shard.docs[i] = d
+		totalLen += uint64(d.docLen)
+	}
+	if docCount > 0 {
+		shard.avgDocLen = float64(totalLen) / float64(docCount)
+	}
+
+	for i := uint32(0); i < termCount; i++ {
+		term, entry, err := readShardTerm(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading term %d: %w", path, i, err)
+		}
+		shard.terms[term] = entry
+	}
+
+	return shard, nil
+}
+
+func readUvarintString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func readShardDoc(r *bufio.Reader) (shardDoc, error) {
+	var d shardDoc
+	var err error
+
+	if d.pubNumber, err = readUvarintString(r); err != nil {
+		return d, err
+	}
+	year, err := binary.ReadUvarint(r)
+	if err != nil {
+		return d, err
+	}
+	d.year = int(year)
+
+	docLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return d, err
+	}
+	d.docLen = int(docLen)
+
+	if d.title, err = readUvarintString(r); err != nil {
+		return d, err
+	}
+	if d.summary, err = readUvarintString(r); err != nil {
+		return d, err
+	}
+
+	cpcCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		return d, err
+	}
+	d.cpcCodes = make([]string, cpcCount)
+	for i := range d.cpcCodes {
+		if d.cpcCodes[i], err = readUvarintString(r); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+func readShardTerm(r *bufio.Reader) (string, *postingEntry, error) {
+	term, err := readUvarintString(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	postingCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entry := &postingEntry{
+		docIDs:    make([]int32, postingCount),
+		freqs:     make([]int32, postingCount),
+		positions: make([][]int32, postingCount),
+	}
+
+	var prevDocID int32
+	for i := uint64(0); i < postingCount; i++ {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", nil, err
+		}
+		prevDocID += int32(delta)
+		entry.docIDs[i] = prevDocID
+
+		freq, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", nil, err
+		}
+		entry.freqs[i] = int32(freq)
+
+		posCount, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", nil, err
+		}
+		positions := make([]int32, posCount)
+		var prevPos int32
+		for p := uint64(0); p < posCount; p++ {
+			delta, err := binary.ReadUvarint(r)
+			if err != nil {
+				return "", nil, err
+			}
+			prevPos += int32(delta)
+			positions[p] = prevPos
+		}
+		entry.positions[i] = positions
+	}
+
+	return term, entry, nil
+}
+
+// availableShardYears lists every year with a shard file under
+// cfg.ArchiveBase/.index/.
+func availableShardYears() ([]int, error) {
+	entries, err := os.ReadDir(searchIndexDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var years []int
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".shard") {
+			continue
+		}
+		yearStr := strings.TrimSuffix(name, ".shard")
+		year, err := strconv.Atoi(yearStr)
+		if err != nil {
+			continue
+		}
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	return years, nil
+}
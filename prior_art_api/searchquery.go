@@ -0,0 +1,617 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Searcher: query parsing, boolean/phrase/prefix evaluation, and BM25 ranking
+// over the shards built by searchindex.go. A shard is cached in memory keyed
+// by year, refreshed whenever its file's mtime changes. (tarIndexCache, by
+// contrast, checks TAR size/mtime only when it loads a sidecar from disk.)
+// ============================================================================
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	defaultSearchLimit  = 20
+	snippetWindow       = 120
+	snippetFallbackSize = 160
+)
+
+var searchFieldNames = map[string]bool{
+	"title":    true,
+	"claim":    true,
+	"inventor": true,
+	"cpc":      true,
+}
+
+// ----------------------------------------------------------------------------
+// Query parsing
+//
+// Grammar is intentionally flat (no parentheses): each clause is implicitly
+// "must" match unless immediately preceded by OR (making it "should") or NOT
+// (making it "must not") - the same model classic Lucene-style query parsers
+// use without a full boolean expression tree. A clause is a field-scoped or
+// unscoped term, a quoted phrase, or a trailing-`*` prefix.
+// ----------------------------------------------------------------------------
+
+type searchClause struct {
+	kind        string // "must", "should", "mustNot"
+	field       string // "", "title", "claim", "inventor", "cpc"
+	term        string
+	prefix      string
+	phraseTerms []string
+}
+
+type lexTok struct {
+	text     string
+	isPhrase bool
+}
+
+// lexSearchQuery splits q into words and quoted phrases, leaving AND/OR/NOT
+// and field:value prefixes intact for parseSearchQuery to interpret.
+func lexSearchQuery(q string) []lexTok {
+	runes := []rune(q)
+	n := len(runes)
+	var toks []lexTok
+
+	for i := 0; i < n; {
+		if runes[i] == ' ' || runes[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < n && runes[i] != ' ' && runes[i] != '\t' && runes[i] != '"' {
+			i++
+		}
+		word := string(runes[start:i])
+
+		if i < n && runes[i] == '"' {
+			qStart := i + 1
+			j := qStart
+			for j < n && runes[j] != '"' {
+				j++
+			}
+			phrase := string(runes[qStart:j])
+			if j < n {
+				j++
+			}
+			toks = append(toks, lexTok{text: word + phrase, isPhrase: true})
+			i = j
+			continue
+		}
+
+		if word != "" {
+			toks = append(toks, lexTok{text: word})
+		}
+	}
+	return toks
+}
+
+// splitField pulls a "field:" prefix off text when field is one of the
+// supported field-scoped query names.
+func splitField(text string) (field, rest string) {
+	idx := strings.IndexByte(text, ':')
+	if idx <= 0 {
+		return "", text
+	}
+	candidate := text[:idx]
+	if searchFieldNames[candidate] {
+		return candidate, text[idx+1:]
+	}
+	return "", text
+}
+
+// parseSearchQuery parses a query string into an ordered list of clauses.
+func parseSearchQuery(q string) ([]searchClause, error) {
+	toks := lexSearchQuery(q)
+	var clauses []searchClause
+	kind := "must"
+
+	for _, t := range toks {
+		if !t.isPhrase {
+			switch t.text {
+			case "AND":
+				kind = "must"
+				continue
+			case "OR":
+				kind = "should"
+				continue
+			case "NOT":
+				kind = "mustNot"
+				continue
+			}
+		}
+
+		field, rest := splitField(t.text)
+
+		switch {
+		case t.isPhrase:
+			terms := analyzeText(rest)
+			if len(terms) > 0 {
+				clauses = append(clauses, searchClause{kind: kind, field: field, phraseTerms: terms})
+			}
+		case strings.HasSuffix(rest, "*") && len(rest) > 1:
+			clauses = append(clauses, searchClause{
+				kind: kind, field: field,
+				prefix: strings.ToLower(strings.TrimSuffix(rest, "*")),
+			})
+		case field == "cpc":
+			// CPC/IPC codes are indexed whole (see cpcCodeString/addCode) and
+			// must be looked up whole too - running "h04l29/06" through the
+			// word tokenizer would split it into "h04l29" and "06".
+			if code := strings.ToLower(rest); code != "" {
+				clauses = append(clauses, searchClause{kind: kind, field: field, term: code})
+			}
+		default:
+			for _, term := range analyzeText(rest) {
+				clauses = append(clauses, searchClause{kind: kind, field: field, term: term})
+			}
+		}
+		kind = "must"
+	}
+
+	if len(clauses) == 0 {
+		return nil, errEmptyQuery
+	}
+	return clauses, nil
+}
+
+var errEmptyQuery = &searchError{"empty query"}
+
+type searchError struct{ msg string }
+
+func (e *searchError) Error() string { return e.msg }
+
+// ----------------------------------------------------------------------------
+// BM25 ranking
+// ----------------------------------------------------------------------------
+
+func termKey(field, term string) string {
+	if field == "" {
+		return term
+	}
+	return field + ":" + term
+}
+
+func bm25Score(shard *indexShard, entry *postingEntry, docID int32, freq int32) float64 {
+	if shard.avgDocLen == 0 {
+		return 0
+	}
+	n := float64(len(shard.docs))
+	df := float64(len(entry.docIDs))
+	idf := math.Log((n-df+0.5)/(df+0.5) + 1)
+
+	doc := shard.docs[docID]
+	denom := float64(freq) + bm25K1*(1-bm25B+bm25B*float64(doc.docLen)/shard.avgDocLen)
+	if denom == 0 {
+		return 0
+	}
+	return idf * (float64(freq) * (bm25K1 + 1)) / denom
+}
+
+func findDocIndex(entry *postingEntry, docID int32) (int, bool) {
+	idx := sort.Search(len(entry.docIDs), func(i int) bool { return entry.docIDs[i] >= docID })
+	if idx < len(entry.docIDs) && entry.docIDs[idx] == docID {
+		return idx, true
+	}
+	return -1, false
+}
+
+func evaluateTermClause(shard *indexShard, field, term string) map[int32]float64 {
+	entry := shard.terms[termKey(field, term)]
+	if entry == nil {
+		return nil
+	}
+	result := make(map[int32]float64, len(entry.docIDs))
+	for i, docID := range entry.docIDs {
+		result[docID] = bm25Score(shard, entry, docID, entry.freqs[i])
+	}
+	return result
+}
+
+func evaluatePrefixClause(shard *indexShard, field, prefix string) map[int32]float64 {
+	fullPrefix := termKey(field, prefix)
+	result := make(map[int32]float64)
+	for term, entry := range shard.terms {
+		if !strings.HasPrefix(term, fullPrefix) {
+			continue
+		}
+		for i, docID := range entry.docIDs {
+			result[docID] += bm25Score(shard, entry, docID, entry.freqs[i])
+		}
+	}
+	return result
+}
+
+func evaluatePhraseClause(shard *indexShard, field string, terms []string) map[int32]float64 {
+	entries := make([]*postingEntry, len(terms))
+	for i, term := range terms {
+		entry := shard.terms[termKey(field, term)]
+		if entry == nil {
+			return nil
+		}
+		entries[i] = entry
+	}
+
+	result := make(map[int32]float64)
+	for _, docID := range entries[0].docIDs {
+		if !phraseMatchesAt(entries, docID) {
+			continue
+		}
+		var score float64
+		for _, entry := range entries {
+			idx, ok := findDocIndex(entry, docID)
+			if !ok {
+				continue
+			}
+			score += bm25Score(shard, entry, docID, entry.freqs[idx])
+		}
+		result[docID] = score
+	}
+	return result
+}
+
+// phraseMatchesAt checks whether docID has entries[0..n-1]'s terms at
+// consecutive positions (position of entries[k] == position of entries[0]+k).
+func phraseMatchesAt(entries []*postingEntry, docID int32) bool {
+	firstIdx, ok := findDocIndex(entries[0], docID)
+	if !ok {
+		return false
+	}
+
+	positionSets := make([]map[int32]bool, len(entries))
+	for k := 1; k < len(entries); k++ {
+		idx, ok := findDocIndex(entries[k], docID)
+		if !ok {
+			return false
+		}
+		set := make(map[int32]bool, len(entries[k].positions[idx]))
+		for _, p := range entries[k].positions[idx] {
+			set[p] = true
+		}
+		positionSets[k] = set
+	}
+
+	for _, start := range entries[0].positions[firstIdx] {
+		matched := true
+		for k := 1; k < len(entries); k++ {
+			if !positionSets[k][start+int32(k)] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func evaluateClause(shard *indexShard, c searchClause) map[int32]float64 {
+	switch {
+	case len(c.phraseTerms) > 0:
+		return evaluatePhraseClause(shard, c.field, c.phraseTerms)
+	case c.prefix != "":
+		return evaluatePrefixClause(shard, c.field, c.prefix)
+	default:
+		return evaluateTermClause(shard, c.field, c.term)
+	}
+}
+
+// evaluateShardQuery runs every clause against one shard and combines them:
+// must-clauses intersect, should-clauses add bonus score to whatever
+// survives (or unions the result set when there are no must clauses),
+// must-not-clauses exclude.
+func evaluateShardQuery(shard *indexShard, clauses []searchClause) map[int32]float64 {
+	var mustResults, shouldResults []map[int32]float64
+	mustNotDocs := make(map[int32]bool)
+
+	for _, c := range clauses {
+		matches := evaluateClause(shard, c)
+		switch c.kind {
+		case "mustNot":
+			for docID := range matches {
+				mustNotDocs[docID] = true
+			}
+		case "should":
+			shouldResults = append(shouldResults, matches)
+		default:
+			mustResults = append(mustResults, matches)
+		}
+	}
+
+	var candidates map[int32]float64
+	if len(mustResults) > 0 {
+		candidates = mustResults[0]
+		for _, m := range mustResults[1:] {
+			next := make(map[int32]float64, len(candidates))
+			for docID, score := range candidates {
+				if s2, ok := m[docID]; ok {
+					next[docID] = score + s2
+				}
+			}
+			candidates = next
+		}
+		for _, m := range shouldResults {
+			for docID, score := range m {
+				if _, ok := candidates[docID]; ok {
+					candidates[docID] += score
+				}
+			}
+		}
+	} else {
+		candidates = make(map[int32]float64)
+		for _, m := range shouldResults {
+			for docID, score := range m {
+				candidates[docID] += score
+			}
+		}
+	}
+
+	for docID := range mustNotDocs {
+		delete(candidates, docID)
+	}
+	return candidates
+}
+
+// ----------------------------------------------------------------------------
+// Shard cache (re-stats the shard file on every lookup)
+// ----------------------------------------------------------------------------
+
+type cachedShard struct {
+	shard   *indexShard
+	modTime time.Time
+}
+
+var (
+	shardCache   = make(map[int]*cachedShard)
+	shardCacheMu sync.Mutex
+)
+
+func ensureShardLoaded(year int) (*indexShard, error) {
+	info, err := os.Stat(shardPath(year))
+	if err != nil {
+		return nil, err
+	}
+
+	shardCacheMu.Lock()
+	defer shardCacheMu.Unlock()
+
+	if c, ok := shardCache[year]; ok && c.modTime.Equal(info.ModTime()) {
+		return c.shard, nil
+	}
+
+	shard, err := loadShard(year)
+	if err != nil {
+		return nil, err
+	}
+	shardCache[year] = &cachedShard{shard: shard, modTime: info.ModTime()}
+	return shard, nil
+}
+
+// ----------------------------------------------------------------------------
+// Top-level search
+// ----------------------------------------------------------------------------
+
+type searchHit struct {
+	PubNumber string   `json:"pub_number"`
+	Year      int      `json:"year"`
+	Title     string   `json:"title"`
+	Score     float64  `json:"score"`
+	Snippet   string   `json:"snippet"`
+	CPC       []string `json:"cpc,omitempty"`
+}
+
+type searchResponse struct {
+	Success bool                      `json:"success"`
+	Query   string                    `json:"query"`
+	Total   int                       `json:"total"`
+	Hits    []searchHit               `json:"hits"`
+	Facets  map[string]map[string]int `json:"facets,omitempty"`
+	Error   string                    `json:"error,omitempty"`
+}
+
+// runSearch parses q, evaluates it against every requested year's shard (all
+// available years when years is empty), and returns the top `limit` hits by
+// BM25 score plus any requested facet counts.
+func runSearch(q string, years []int, limit int, facetNames []string) (*searchResponse, error) {
+	clauses, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(years) == 0 {
+		years, err = availableShardYears()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var hits []searchHit
+	facets := make(map[string]map[string]int)
+	for _, facet := range facetNames {
+		facets[facet] = make(map[string]int)
+	}
+
+	for _, year := range years {
+		shard, err := ensureShardLoaded(year)
+		if err != nil {
+			log.Printf("search: skipping year %d: %v", year, err)
+			continue
+		}
+
+		matches := evaluateShardQuery(shard, clauses)
+		for docID, score := range matches {
+			doc := shard.docs[docID]
+			hits = append(hits, searchHit{
+				PubNumber: doc.pubNumber,
+				Year:      doc.year,
+				Title:     doc.title,
+				Score:     score,
+				Snippet:   buildSnippet(doc, clauses),
+				CPC:       doc.cpcCodes,
+			})
+			for _, facet := range facetNames {
+				addFacetCount(facets[facet], facet, doc)
+			}
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+
+	total := len(hits)
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	resp := &searchResponse{Success: true, Query: q, Total: total, Hits: hits}
+	if len(facetNames) > 0 {
+		resp.Facets = facets
+	}
+	return resp, nil
+}
+
+func addFacetCount(counts map[string]int, facet string, doc shardDoc) {
+	switch facet {
+	case "year":
+		counts[strconv.Itoa(doc.year)]++
+	case "cpc":
+		for _, code := range doc.cpcCodes {
+			counts[code]++
+		}
+	}
+}
+
+// buildSnippet renders a short excerpt around the first matching clause term
+// found in the doc's stored abstract summary, falling back to the start of
+// the summary when none of the query terms appear in it (common for hits
+// that only matched in the description or claims, which aren't stored in
+// full - see summaryMaxRunes). This is a deliberately simpler scheme than
+// per-field highlight offsets: storing enough of every field to highlight
+// any match anywhere would make shard files as large as the archive itself.
+func buildSnippet(doc shardDoc, clauses []searchClause) string {
+	lowerSummary := strings.ToLower(doc.summary)
+
+	for _, c := range clauses {
+		if c.kind == "mustNot" {
+			continue
+		}
+		term := c.term
+		if term == "" && len(c.phraseTerms) > 0 {
+			term = c.phraseTerms[0]
+		}
+		if term == "" {
+			term = c.prefix
+		}
+		if term == "" {
+			continue
+		}
+
+		idx := strings.Index(lowerSummary, term)
+		if idx < 0 {
+			continue
+		}
+		start := idx - snippetWindow/2
+		if start < 0 {
+			start = 0
+		}
+		end := idx + len(term) + snippetWindow/2
+		if end > len(doc.summary) {
+			end = len(doc.summary)
+		}
+
+		snippet := doc.summary[start:end]
+		if start > 0 {
+			snippet = "..." + snippet
+		}
+		if end < len(doc.summary) {
+			snippet += "..."
+		}
+		return snippet
+	}
+
+	return truncateText(doc.summary, snippetFallbackSize)
+}
+
+// ----------------------------------------------------------------------------
+// Background reindex watcher
+//
+// Polling rather than an fsnotify watch: the rest of this binary has no
+// third-party dependencies beyond golang.org/x/image and lib/pq, and a
+// once-a-minute stat of each year directory's newest TAR mtime against its
+// shard's mtime is cheap enough not to justify adding one just for this.
+// ----------------------------------------------------------------------------
+
+func watchForNewArchives(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		years, err := listYearDirs()
+		if err != nil {
+			log.Printf("reindex watcher: %v", err)
+			continue
+		}
+
+		for _, year := range years {
+			if !archiveNeedsReindex(year) {
+				continue
+			}
+			log.Printf("reindex watcher: year %d has new/updated archives, rebuilding index", year)
+			if err := buildYearIndex(year); err != nil {
+				log.Printf("reindex watcher: year %d: %v", year, err)
+			}
+		}
+	}
+}
+
+func listYearDirs() ([]int, error) {
+	entries, err := os.ReadDir(cfg.ArchiveBase)
+	if err != nil {
+		return nil, err
+	}
+	var years []int
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == searchIndexDirName {
+			continue
+		}
+		if y, err := strconv.Atoi(e.Name()); err == nil {
+			years = append(years, y)
+		}
+	}
+	sort.Ints(years)
+	return years, nil
+}
+
+func archiveNeedsReindex(year int) bool {
+	yearDir := filepath.Join(cfg.ArchiveBase, strconv.Itoa(year))
+	tarPaths, err := listTarFiles(yearDir)
+	if err != nil || len(tarPaths) == 0 {
+		return false
+	}
+
+	shardInfo, err := os.Stat(shardPath(year))
+	if err != nil {
+		return true
+	}
+	for _, p := range tarPaths {
+		info, err := os.Stat(p)
+		if err == nil && info.ModTime().After(shardInfo.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ============================================================================
+// Per-TAR offset index: a sidecar "<tar>.idx" next to each weekly TAR mapping
+// patentDir -> where its ZIP lives in the TAR, so a cache miss in
+// getCachedZIP costs one os.File.ReadAt instead of extractZIPFromTAR's
+// sequential walk through however much of a multi-GB TAR precedes it. Built
+// lazily on first access per TAR; getCachedZIP (via getZIPViaIndex and
+// ensureTarIndex) triggers the build if the
+// sidecar is missing or stale (tar size/mtime changed underneath it).
+//
+// This lives directly in package main rather than a pkg/tarindex, matching
+// how patentindex.go's patents.idx reader is laid out in this same binary -
+// this repo doesn't split into internal packages.
+// ============================================================================
+
+const (
+	tarIdxMagic      = "TARIDX01"
+	tarIdxHeaderSize = 8 + 4 + 8 + 8 + 4 + 4 // magic + version + tar size + tar mtime + record count + crc32(records)
+
+	tarIdxDirLen     = 160
+	tarIdxMemberLen  = 200
+	tarIdxRecordSize = tarIdxDirLen + tarIdxMemberLen + 8 + 8 + 8 + 4 // dir + member + headerOffset + dataOffset + size + crc32
+)
+
+type tarIndexRecord struct {
+	patentDir    string
+	memberName   string
+	headerOffset int64
+	dataOffset   int64
+	size         int64
+	crc32        uint32
+}
+
+// tarIndex is one loaded "<tar>.idx" sidecar: a bloom filter to short-circuit
+// lookups for patentDirs that definitely aren't in this TAR, plus the full
+// record list for everything that might be.
+type tarIndex struct {
+	tarSize    int64
+	tarModTime int64
+	bloom      *bloomFilter
+	records    map[string]tarIndexRecord
+}
+
+func encodeFixed(s string, width int) []byte {
+	b := make([]byte, width)
+	copy(b, s)
+	return b
+}
+
+func decodeFixed(buf []byte) string {
+	i := 0
+	for i < len(buf) && buf[i] != 0 {
+		i++
+	}
+	return string(buf[:i])
+}
+
+// buildTarIndex streams tarPath once with tar.NewReader, recording each
+// member's offsets via a byte-counting wrapper around the file (rather than
+// relying on the file's own Seek position, which archive/tar's internal
+// buffering doesn't guarantee tracks 1:1 with bytes consumed from the
+// io.Reader it was given).
+func buildTarIndex(tarPath string) (*tarIndex, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &countingReader{r: f}
+	tr := tar.NewReader(cr)
+
+	var dirs []string
+	records := make(map[string]tarIndexRecord)
+
+	for {
+		headerOffset := cr.n
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading TAR %s: %w", tarPath, err)
+		}
+		dataOffset := cr.n
+
+		if !strings.HasSuffix(strings.ToUpper(hdr.Name), ".ZIP") {
+			continue
+		}
+
+		h := crc32.NewIEEE()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, fmt.Errorf("hashing %s in %s: %w", hdr.Name, tarPath, err)
+		}
+
+		patentDir := strings.TrimSuffix(hdr.Name[strings.LastIndex(hdr.Name, "/")+1:], ".ZIP")
+		patentDir = strings.TrimSuffix(patentDir, ".zip")
+		rec := tarIndexRecord{
+			patentDir:    patentDir,
+			memberName:   hdr.Name,
+			headerOffset: headerOffset,
+			dataOffset:   dataOffset,
+			size:         hdr.Size,
+			crc32:        h.Sum32(),
+		}
+		records[patentDir] = rec
+		dirs = append(dirs, patentDir)
+	}
+
+	bloom := newBloomFilter(len(dirs))
+	for _, d := range dirs {
+		bloom.add(d)
+	}
+
+	return &tarIndex{
+		tarSize:    info.Size(),
+		tarModTime: info.ModTime().Unix(),
+		bloom:      bloom,
+		records:    records,
+	}, nil
+}
+
+func (idx *tarIndex) lookup(patentDir string) (tarIndexRecord, bool) {
+	if !idx.bloom.mightContain(patentDir) {
+		return tarIndexRecord{}, false
+	}
+	rec, ok := idx.records[patentDir]
+	return rec, ok
+}
+
+func tarIndexPath(tarPath string) string {
+	return tarPath + ".idx"
+}
+
+func writeTarIndex(tarPath string, idx *tarIndex) error {
+	var body []byte
+	for _, rec := range idx.records {
+		buf := make([]byte, tarIdxRecordSize)
+		o := 0
+		copy(buf[o:], encodeFixed(rec.patentDir, tarIdxDirLen))
+		o += tarIdxDirLen
+		copy(buf[o:], encodeFixed(rec.memberName, tarIdxMemberLen))
+		o += tarIdxMemberLen
+		binary.BigEndian.PutUint64(buf[o:], uint64(rec.headerOffset))
+		o += 8
+		binary.BigEndian.PutUint64(buf[o:], uint64(rec.dataOffset))
+		o += 8
+		binary.BigEndian.PutUint64(buf[o:], uint64(rec.size))
+		o += 8
+		binary.BigEndian.PutUint32(buf[o:], rec.crc32)
+		body = append(body, buf...)
+	}
+
+	header := make([]byte, tarIdxHeaderSize)
+	copy(header[0:8], tarIdxMagic)
+	binary.BigEndian.PutUint32(header[8:12], 1)
+	binary.BigEndian.PutUint64(header[12:20], uint64(idx.tarSize))
+	binary.BigEndian.PutUint64(header[20:28], uint64(idx.tarModTime))
+	binary.BigEndian.PutUint32(header[28:32], uint32(len(idx.records)))
+	binary.BigEndian.PutUint32(header[32:36], crc32.ChecksumIEEE(body))
+
+	out := append(header, idx.bloom.encode()...)
+	out = append(out, body...)
+
+	tmp := tarIndexPath(tarPath) + ".tmp"
+	if err := os.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tarIndexPath(tarPath))
+}
+
+func readTarIndex(tarPath string) (*tarIndex, error) {
+	data, err := os.ReadFile(tarIndexPath(tarPath))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < tarIdxHeaderSize || string(data[:8]) != tarIdxMagic {
+		return nil, fmt.Errorf("%s: bad magic, index may be corrupt or stale", tarIndexPath(tarPath))
+	}
+
+	tarSize := int64(binary.BigEndian.Uint64(data[12:20]))
+	tarModTime := int64(binary.BigEndian.Uint64(data[20:28]))
+	count := binary.BigEndian.Uint32(data[28:32])
+	wantCRC := binary.BigEndian.Uint32(data[32:36])
+
+	rest := data[tarIdxHeaderSize:]
+	bloom, n, err := decodeBloomFilter(rest)
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[n:]
+
+	if uint64(len(rest)) != uint64(count)*uint64(tarIdxRecordSize) {
+		return nil, fmt.Errorf("%s: record count does not match body length", tarIndexPath(tarPath))
+	}
+	if crc32.ChecksumIEEE(rest) != wantCRC {
+		return nil, fmt.Errorf("%s: CRC32 mismatch, index is corrupt", tarIndexPath(tarPath))
+	}
+
+	records := make(map[string]tarIndexRecord, count)
+	for i := uint32(0); i < count; i++ {
+		off := int(i) * tarIdxRecordSize
+		buf := rest[off : off+tarIdxRecordSize]
+		o := 0
+		patentDir := decodeFixed(buf[o : o+tarIdxDirLen])
+		o += tarIdxDirLen
+		memberName := decodeFixed(buf[o : o+tarIdxMemberLen])
+		o += tarIdxMemberLen
+		headerOffset := int64(binary.BigEndian.Uint64(buf[o:]))
+		o += 8
+		dataOffset := int64(binary.BigEndian.Uint64(buf[o:]))
+		o += 8
+		size := int64(binary.BigEndian.Uint64(buf[o:]))
+		o += 8
+		sum := binary.BigEndian.Uint32(buf[o:])
+
+		records[patentDir] = tarIndexRecord{
+			patentDir: patentDir, memberName: memberName,
+			headerOffset: headerOffset, dataOffset: dataOffset,
+			size: size, crc32: sum,
+		}
+	}
+
+	return &tarIndex{tarSize: tarSize, tarModTime: tarModTime, bloom: bloom, records: records}, nil
+}
+
+var (
+	tarIndexCacheMu sync.Mutex
+	tarIndexCache   = make(map[string]*tarIndex)
+)
+
+// ensureTarIndex returns the in-memory tarIndex for tarPath, loading the
+// sidecar from disk if present and still valid for the TAR's current
+// size+mtime, or building and persisting one from scratch otherwise. Callers
+// that only want a best-effort accelerator should treat a non-nil error as
+// "fall back to the sequential walk", not a hard failure.
+func ensureTarIndex(tarPath string) (*tarIndex, error) {
+	tarIndexCacheMu.Lock()
+	if idx, ok := tarIndexCache[tarPath]; ok {
+		tarIndexCacheMu.Unlock()
+		return idx, nil
+	}
+	tarIndexCacheMu.Unlock()
+
+	info, err := os.Stat(tarPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if idx, err := readTarIndex(tarPath); err == nil {
+		if idx.tarSize == info.Size() && idx.tarModTime == info.ModTime().Unix() {
+			tarIndexCacheMu.Lock()
+			tarIndexCache[tarPath] = idx
+			tarIndexCacheMu.Unlock()
+			return idx, nil
+		}
+		log.Printf("TAR index for %s is stale (size/mtime changed), rebuilding", tarPath)
+	}
+
+	log.Printf("Building TAR index for %s", tarPath)
+	idx, err := buildTarIndex(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarIndex(tarPath, idx); err != nil {
+		log.Printf("Failed to persist TAR index for %s: %v", tarPath, err)
+	}
+
+	tarIndexCacheMu.Lock()
+	tarIndexCache[tarPath] = idx
+	tarIndexCacheMu.Unlock()
+	return idx, nil
+}
+
+// readZIPAt reads a ZIP member straight out of tarPath at the offsets
+// buildTarIndex recorded, skipping the sequential walk entirely.
+func readZIPAt(tarPath string, rec tarIndexRecord) ([]byte, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, rec.size)
+	if _, err := f.ReadAt(data, rec.dataOffset); err != nil {
+		return nil, fmt.Errorf("reading %s at offset %d: %w", rec.memberName, rec.dataOffset, err)
+	}
+	if crc32.ChecksumIEEE(data) != rec.crc32 {
+		return nil, fmt.Errorf("%s: CRC mismatch against index, TAR may have changed underneath it", rec.memberName)
+	}
+	return data, nil
+}
+
+// countingReader tracks how many bytes have been read through it, giving
+// buildTarIndex a byte-accurate offset independent of how archive/tar
+// buffers internally.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ============================================================================
+// Bloom filter: lets ensureTarIndex's caller short-circuit a lookup for a
+// patentDir that definitely isn't in this TAR without touching the (possibly
+// large) records map at all.
+// ============================================================================
+
+type bloomFilter struct {
+	bits   []byte
+	nBits  uint32
+	hashes uint32
+}
+
+// newBloomFilter sizes the filter for n items at roughly a 1% false-positive
+// rate (the standard ~9.6 bits/item, rounded up to a byte boundary) with 7
+// hash functions, the usual choice at that bit-per-item budget.
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	nBits := uint32(n*10 + 64)
+	nBits = (nBits + 7) / 8 * 8
+	return &bloomFilter{
+		bits:   make([]byte, nBits/8),
+		nBits:  nBits,
+		hashes: 7,
+	}
+}
+
+// positions derives the i'th hash position from two FNV-1a digests (the
+// second over s plus a 0xff byte) via double hashing (h1 + i*h2), avoiding
+// a separate hash computation per position.
+func (bf *bloomFilter) positions(s string) []uint32 {
+	h1 := fnv.New64a()
+	h1.Write([]byte(s))
+	sum1 := h1.Sum64()
+
+	h2 := fnv.New64a()
+	h2.Write([]byte(s))
+	h2.Write([]byte{0xff})
+	sum2 := h2.Sum64()
+
+	positions := make([]uint32, bf.hashes)
+	for i := uint32(0); i < bf.hashes; i++ {
+		combined := sum1 + uint64(i)*sum2
+		positions[i] = uint32(combined % uint64(bf.nBits))
+	}
+	return positions
+}
+
+func (bf *bloomFilter) add(s string) {
+	for _, pos := range bf.positions(s) {
+		bf.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+func (bf *bloomFilter) mightContain(s string) bool {
+	for _, pos := range bf.positions(s) {
+		if bf.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encode serializes the filter as [nBits uint32][hashes uint32][bits...].
+func (bf *bloomFilter) encode() []byte {
+	out := make([]byte, 8+len(bf.bits))
+	binary.BigEndian.PutUint32(out[0:4], bf.nBits)
+	binary.BigEndian.PutUint32(out[4:8], bf.hashes)
+	copy(out[8:], bf.bits)
+	return out
+}
+
+func decodeBloomFilter(data []byte) (*bloomFilter, int, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("bloom filter header truncated")
+	}
+	nBits := binary.BigEndian.Uint32(data[0:4])
+	hashes := binary.BigEndian.Uint32(data[4:8])
+	nBytes := int(nBits / 8)
+	if len(data) < 8+nBytes {
+		return nil, 0, fmt.Errorf("bloom filter body truncated")
+	}
+	bits := make([]byte, nBytes)
+	copy(bits, data[8:8+nBytes])
+	return &bloomFilter{bits: bits, nBits: nBits, hashes: hashes}, 8 + nBytes, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+func archiveShardBucket(seed, name string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(seed + "." + name))
+	return float64(h.Sum64()) / float64(math.MaxUint64) * 100000
+}
+
+func inShard(seed, name string, shardIndex, shardCount int) bool {
+	if shardCount <= 1 {
+		return true
+	}
+	bucket := archiveShardBucket(seed, name)
+	width := 100000.0 / float64(shardCount)
+	lo := float64(shardIndex) * width
+	hi := float64(shardIndex+1) * width
+	return bucket >= lo && bucket < hi
+}
+
+func main() {
+	failures := 0
+
+	// Stability: hashing the same name+seed repeatedly gives the same bucket.
+	name := "ipa230615.zip"
+	first := archiveShardBucket("prod", name)
+	for i := 0; i < 5; i++ {
+		if got := archiveShardBucket("prod", name); got != first {
+			fmt.Printf("FAIL stability: run %d got %f, want %f\n", i, got, first)
+			failures++
+		}
+	}
+	if failures == 0 {
+		fmt.Println("PASS stability across repeated runs")
+	}
+
+	// Every archive lands in exactly one shard of a given ShardCount.
+	names := make([]string, 5000)
+	for i := range names {
+		names[i] = fmt.Sprintf("archive-%05d.zip", i)
+	}
+	const shardCount = 4
+	owners := make(map[string]int)
+	before := failures
+	for _, n := range names {
+		owned := 0
+		for s := 0; s < shardCount; s++ {
+			if inShard("prod", n, s, shardCount) {
+				owned++
+				owners[n] = s
+			}
+		}
+		if owned != 1 {
+			fmt.Printf("FAIL partition: %s landed in %d shards of %d, want exactly 1\n", n, owned, shardCount)
+			failures++
+		}
+	}
+	if failures == before {
+		fmt.Printf("PASS every archive assigned to exactly one of %d shards\n", shardCount)
+	}
+
+	// Changing ShardSeed fully reshuffles assignments (mixed into the hash
+	// input, not xor'd onto the result), rather than just rotating them.
+	reassigned := 0
+	for _, n := range names {
+		newOwner := -1
+		for s := 0; s < shardCount; s++ {
+			if inShard("prod-v2", n, s, shardCount) {
+				newOwner = s
+				break
+			}
+		}
+		if newOwner != owners[n] {
+			reassigned++
+		}
+	}
+	reshuffleFrac := float64(reassigned) / float64(len(names))
+	if reshuffleFrac < 0.5 {
+		fmt.Printf("FAIL reseed: only %.1f%% of archives moved after reseeding, want a near-total reshuffle\n", reshuffleFrac*100)
+		failures++
+	} else {
+		fmt.Printf("PASS reseeding reshuffled %.1f%% of archives\n", reshuffleFrac*100)
+	}
+
+	// Doubling ShardCount from N to 2N should move roughly half of a given
+	// shard's archives to a new owner, with the rest staying put (since
+	// shard i of N splits exactly into shards 2i and 2i+1 of 2N).
+	const n2 = shardCount * 2
+	var ownedByShard0, stillOwnedByShard0 int
+	for _, n := range names {
+		if owners[n] != 0 {
+			continue
+		}
+		ownedByShard0++
+		if inShard("prod", n, 0, n2) {
+			stillOwnedByShard0++
+		}
+	}
+	frac := float64(stillOwnedByShard0) / float64(ownedByShard0)
+	if frac < 0.4 || frac > 0.6 {
+		fmt.Printf("FAIL reshard: %.1f%% of shard 0's archives stayed in shard 0 of %d, want ~50%%\n", frac*100, n2)
+		failures++
+	} else {
+		fmt.Printf("PASS doubling ShardCount kept %.1f%% of shard 0's archives (want ~50%%)\n", frac*100)
+	}
+
+	if failures > 0 {
+		fmt.Printf("\n%d case(s) FAILED\n", failures)
+	} else {
+		fmt.Println("\nAll cases PASSED")
+	}
+}
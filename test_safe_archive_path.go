@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func safeArchivePath(name string) (string, error) {
+	cleaned := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(cleaned, "/") {
+		return "", fmt.Errorf("archive path %q is absolute", name)
+	}
+	for _, seg := range strings.Split(cleaned, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("archive path %q contains a \"..\" segment", name)
+		}
+	}
+	return strings.ToLower(cleaned), nil
+}
+
+func main() {
+	cases := []struct {
+		name      string
+		input     string
+		wantOK    bool
+		wantClean string
+	}{
+		{"plain zip entry", "US20030046754A1-20030313.ZIP", true, "us20030046754a1-20030313.zip"},
+		{"nested dir entry", "UTIL0046/US20030046754A1-20030313.ZIP", true, "util0046/us20030046754a1-20030313.zip"},
+		{"windows-style separators", `UTIL0046\US20030046754A1-20030313.ZIP`, true, "util0046/us20030046754a1-20030313.zip"},
+		{"absolute unix path", "/etc/passwd", false, ""},
+		{"simple traversal", "../../etc/passwd", false, ""},
+		{"traversal buried mid-path", "UTIL0046/../../etc/passwd", false, ""},
+		{"traversal via backslashes", `..\..\etc\passwd`, false, ""},
+		{"trailing traversal segment", "UTIL0046/..", false, ""},
+		{"dotted filename is not traversal", "US2003..0046754A1-20030313.ZIP", true, "us2003..0046754a1-20030313.zip"},
+	}
+
+	failures := 0
+	for _, c := range cases {
+		got, err := safeArchivePath(c.input)
+		ok := err == nil
+		if ok != c.wantOK {
+			fmt.Printf("FAIL %s: input=%q wantOK=%v gotOK=%v err=%v\n", c.name, c.input, c.wantOK, ok, err)
+			failures++
+			continue
+		}
+		if ok && got != c.wantClean {
+			fmt.Printf("FAIL %s: input=%q want=%q got=%q\n", c.name, c.input, c.wantClean, got)
+			failures++
+			continue
+		}
+		fmt.Printf("PASS %s\n", c.name)
+	}
+
+	if failures > 0 {
+		fmt.Printf("\n%d case(s) FAILED\n", failures)
+	} else {
+		fmt.Println("\nAll cases PASSED")
+	}
+}